@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log/slog"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/debug"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/notify"
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/plugin"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/tracing"
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
 
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
 	goPlugin "github.com/hashicorp/go-plugin"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 )
 
 // handshakeConfigs are used to just do a basic handshake between
@@ -22,13 +33,117 @@ var handshakeConfig = goPlugin.HandshakeConfig{
 }
 
 var lvl = flag.Int("l", int(slog.LevelInfo), "the logging level for 'log/slog', (default: 0)")
+var logFormat = flag.String("log-format", utils.LogFormatText, "the format of the logs, text or json")
+var conflictRetries = flag.Int("conflict-retries", 5, "the number of times a httpproxy patch is retried on a conflict")
+var fieldManager = flag.String("field-manager", plugin.DefaultFieldManager, "the name of the manager of the fields written by the plugin")
+var concurrency = flag.Int("concurrency", 10, "the maximum number of httpproxies which are read or written at once")
+var timeout = flag.Duration("timeout", envDuration("CONTOUR_PLUGIN_TIMEOUT", 30*time.Second), "the deadline of every request to the API server, 0 means no deadline (env: CONTOUR_PLUGIN_TIMEOUT)")
+var validationWait = flag.Duration("validation-wait", 0, "how long the Valid condition of the updated httpproxies is watched, they are restored when contour rejects them, 0 disables the watch")
+var enableCache = flag.Bool("cache", false, "read the httpproxies from informers instead of the API server")
+var cacheNamespaces = flag.String("cache-namespaces", "", "a comma separated list of the namespaces whose httpproxy informers are started with the plugin, the informers of other namespaces are started on their first use")
+var cacheResync = flag.Duration("cache-resync", 10*time.Minute, "the resync period of the httpproxy informers")
+var selfHeal = flag.Bool("self-heal", false, "restore the weights of the httpproxies when they are changed by someone else during a rollout")
+var envoyAdminURL = flag.String("envoy-admin-url", "", "the URL of the admin endpoint of an envoy whose configuration must have the weights before they are verified")
+var envoyNamespace = flag.String("envoy-namespace", "projectcontour", "the namespace of the envoy pods selected by -envoy-pod-selector")
+var envoyPodSelector = flag.String("envoy-pod-selector", "", "the label selector of the envoy pods whose configurations must have the weights before they are verified")
+var envoyAdminPort = flag.Int("envoy-admin-port", plugin.DefaultEnvoyAdminPort, "the port of the admin endpoint of the envoy pods, which must listen on the pod IP and not only on 127.0.0.1 as the admin listener of a stock Contour does")
+var metricsAddr = flag.String("metrics-addr", "", "the address the prometheus metrics are served on, e.g. :8090, empty disables them")
+var otlpEndpoint = flag.String("otlp-endpoint", "", "the OTLP gRPC endpoint the traces are exported to, e.g. otel-collector.monitoring:4317, empty disables them")
+var otlpInsecure = flag.Bool("otlp-insecure", false, "export the traces without TLS")
+var weightHistory = flag.Int("weight-history", 0, "the number of weight changes kept in the history annotation of the httpproxies, 0 disables the history")
+var logLevelConfigMap = flag.String("log-level-configmap", "", "the namespace/name of a configmap whose log-level key sets the log level at runtime, empty disables the watch")
+var strictInit = flag.Bool("strict-init", false, "fail the start of the plugin when the HTTPProxy CRD isn't served or a permission is missing, instead of logging it")
+var webhookConfig = flag.String("webhook-config", "", "the path of the YAML or JSON configuration of the webhooks notified of the weight changes and the errors, empty disables them")
+var statusAddr = flag.String("status-addr", "", "the address the read-only status API of the managed httpproxies is served on, e.g. :8091, empty disables it")
+var pprofPort = flag.Int("pprof-port", 0, "the loopback port the pprof profiles, a goroutine dump and the log level are served on, 0 disables them")
 
 func main() {
 	flag.Parse()
 
-	utils.InitLogger(slog.Level(*lvl))
+	if err := utils.InitLogger(slog.Level(*lvl), *logFormat); err != nil {
+		slog.Error("failed to init the logger", slog.Any("err", err))
+		os.Exit(1)
+	}
+	utils.WatchLogLevelSignal(wait.NeverStop)
+	if *logLevelConfigMap != "" {
+		if err := watchLogLevelConfigMap(*logLevelConfigMap); err != nil {
+			slog.Error("failed to watch the log level configmap", slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	rpcPluginImp := &plugin.RpcPlugin{
+		ConflictRetries:   *conflictRetries,
+		FieldManager:      *fieldManager,
+		Concurrency:       *concurrency,
+		Timeout:           *timeout,
+		ValidationWait:    *validationWait,
+		EnableCache:       *enableCache,
+		CacheNamespaces:   splitList(*cacheNamespaces),
+		CacheResync:       *cacheResync,
+		SelfHeal:          *selfHeal,
+		EnvoyAdminURL:     *envoyAdminURL,
+		EnvoyNamespace:    *envoyNamespace,
+		EnvoyPodSelector:  *envoyPodSelector,
+		EnvoyAdminPort:    *envoyAdminPort,
+		WeightHistory:     *weightHistory,
+		StrictInit:        *strictInit,
+		LogLevelConfigMap: *logLevelConfigMap,
+	}
 
-	rpcPluginImp := &plugin.RpcPlugin{}
+	if *webhookConfig != "" {
+		cfg, err := notify.LoadConfig(*webhookConfig)
+		if err == nil {
+			rpcPluginImp.Notifier, err = notify.New(cfg, nil)
+		}
+		if err != nil {
+			slog.Error("failed to configure the webhooks", slog.Any("err", err))
+			os.Exit(1)
+		}
+		rpcPluginImp.Notifier.Start(wait.NeverStop)
+		slog.Info("notifying the webhooks", slog.Int("webhooks", len(cfg.Webhooks)))
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			slog.Info("serving the metrics", slog.String("addr", *metricsAddr))
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				slog.Error("failed to serve the metrics", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if *statusAddr != "" {
+		go func() {
+			slog.Info("serving the status API", slog.String("addr", *statusAddr))
+			if err := rpcPluginImp.ServeStatus(*statusAddr); err != nil {
+				slog.Error("failed to serve the status API", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if *pprofPort != 0 {
+		go func() {
+			slog.Info("serving the pprof profiles", slog.Int("port", *pprofPort))
+			if err := debug.Serve(*pprofPort); err != nil {
+				slog.Error("failed to serve the pprof profiles", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if *otlpEndpoint != "" {
+		shutdown, err := tracing.Init(context.Background(), *otlpEndpoint, *otlpInsecure)
+		if err != nil {
+			slog.Error("failed to init the tracing", slog.Any("err", err))
+			os.Exit(1)
+		}
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				slog.Error("failed to flush the traces", slog.Any("err", err))
+			}
+		}()
+		slog.Info("exporting the traces", slog.String("endpoint", *otlpEndpoint))
+	}
 
 	//  pluginMap is the map of plugins we can dispense.
 	var pluginMap = map[string]goPlugin.Plugin{
@@ -41,3 +156,46 @@ func main() {
 		Plugins:         pluginMap,
 	})
 }
+
+// envDuration returns the duration of the environment variable, or the default one if it's not set or not valid.
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("ignoring the invalid duration", slog.String("env", key), slog.String("value", value))
+		return defaultValue
+	}
+	return d
+}
+
+// watchLogLevelConfigMap watches the log level of the namespace/name configmap.
+func watchLogLevelConfigMap(ref string) error {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("the configmap must be namespace/name: %q", ref)
+	}
+	cfg, err := utils.NewKubeConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	utils.WatchLogLevelConfigMap(clientset, namespace, name, 10*time.Minute, wait.NeverStop)
+	return nil
+}
+
+// splitList splits a comma separated list, the empty items are dropped.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
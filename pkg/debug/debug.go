@@ -0,0 +1,62 @@
+// Package debug serves the pprof profiles, a goroutine dump and the log level of the plugin process.
+package debug
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
+)
+
+// Handler serves the pprof profiles on /debug/pprof/, the stacks of all the goroutines on /debug/goroutines
+// and the log level on /debug/loglevel.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("/debug/loglevel", serveLogLevel)
+	return mux
+}
+
+// serveLogLevel returns the log level, and sets it to the level query parameter on a PUT or a POST.
+func serveLogLevel(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		lvl, err := utils.ParseLogLevel(req.URL.Query().Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		utils.SetLogLevel(lvl)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, utils.LogLevel())
+}
+
+// Serve serves the Handler on the port of the loopback interface until it fails, so the profiles and the log
+// level can only be reached from inside the pod, e.g. with kubectl port-forward.
+func Serve(port int) error {
+	server := &http.Server{
+		Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		Handler:           Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,82 @@
+package debug
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
+)
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/debug/pprof/", want: "goroutine"},
+		{path: "/debug/pprof/heap?debug=1", want: "heap profile"},
+		{path: "/debug/goroutines", want: "goroutine "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s = %s", tt.path, resp.Status)
+			}
+			if !strings.Contains(string(body), tt.want) {
+				t.Errorf("GET %s doesn't contain %q", tt.path, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerSetsTheLogLevel(t *testing.T) {
+	previous := utils.LogLevel()
+	t.Cleanup(func() { utils.SetLogLevel(previous) })
+	utils.SetLogLevel(slog.LevelInfo)
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	tests := []struct {
+		method     string
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK, want: "INFO"},
+		{method: http.MethodPost, query: "?level=debug", wantStatus: http.StatusOK, want: "DEBUG"},
+		{method: http.MethodPut, query: "?level=verbose", wantStatus: http.StatusBadRequest, want: "invalid log level"},
+		{method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, wantStatus: http.StatusOK, want: "DEBUG"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL+"/debug/loglevel"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", tt.method, tt.query, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s %s = %s, want %d", tt.method, tt.query, resp.Status, tt.wantStatus)
+		}
+		if !strings.Contains(string(body), tt.want) {
+			t.Errorf("%s %s = %q, want %q", tt.method, tt.query, body, tt.want)
+		}
+	}
+}
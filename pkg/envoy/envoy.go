@@ -0,0 +1,148 @@
+// Package envoy reads the routes of the live configuration of Envoy from its admin endpoint.
+package envoy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ConfigDumpPath is the path of the admin endpoint which dumps the route configurations of Envoy.
+const ConfigDumpPath = "/config_dump?resource=dynamic_route_configs"
+
+// WeightedCluster is a cluster of a route and its weight.
+type WeightedCluster struct {
+	Name   string
+	Weight int64
+}
+
+// Route is a route of a virtual host which splits the traffic between weighted clusters.
+type Route struct {
+	VirtualHost string
+	Domains     []string
+	Clusters    []WeightedCluster
+}
+
+// HasDomain reports whether the virtual host of the route serves the domain.
+func (r Route) HasDomain(domain string) bool {
+	for _, d := range r.Domains {
+		if d == domain || strings.HasPrefix(d, domain+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchRoutes returns the routes with weighted clusters of the config dump served by the admin endpoint.
+func FetchRoutes(ctx context.Context, client *http.Client, adminURL string) ([]Route, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(adminURL, "/")+ConfigDumpPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the config dump of %s: %w", adminURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the config dump of %s: %s", adminURL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config dump of %s: %w", adminURL, err)
+	}
+	return ParseRoutes(body)
+}
+
+// ParseRoutes returns the routes with weighted clusters of a config dump. The dump is read as plain JSON,
+// every object with virtual hosts is taken as a route configuration wherever it is nested.
+func ParseRoutes(configDump []byte) ([]Route, error) {
+	var dump any
+	if err := json.Unmarshal(configDump, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse the config dump: %w", err)
+	}
+
+	routes := []Route{}
+	walk(dump, func(routeConfig map[string]any) {
+		for _, vh := range asSlice(routeConfig["virtual_hosts"]) {
+			virtualHost := asMap(vh)
+			name, _ := virtualHost["name"].(string)
+			domains := []string{}
+			for _, d := range asSlice(virtualHost["domains"]) {
+				if domain, ok := d.(string); ok {
+					domains = append(domains, domain)
+				}
+			}
+
+			for _, r := range asSlice(virtualHost["routes"]) {
+				weighted := asMap(asMap(asMap(r)["route"])["weighted_clusters"])
+				if weighted == nil {
+					continue
+				}
+				route := Route{VirtualHost: name, Domains: domains}
+				for _, c := range asSlice(weighted["clusters"]) {
+					cluster := asMap(c)
+					clusterName, _ := cluster["name"].(string)
+					route.Clusters = append(route.Clusters, WeightedCluster{Name: clusterName, Weight: asInt(cluster["weight"])})
+				}
+				routes = append(routes, route)
+			}
+		}
+	})
+	return routes, nil
+}
+
+// ClusterService returns the namespace and the name of the service of a cluster named by Contour,
+// i.e. namespace/service/port/hash.
+func ClusterService(cluster string) (namespace, service string, ok bool) {
+	parts := strings.Split(cluster, "/")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// walk calls fn with every object of the JSON value which has virtual hosts.
+func walk(value any, fn func(map[string]any)) {
+	switch v := value.(type) {
+	case map[string]any:
+		if _, ok := v["virtual_hosts"]; ok {
+			fn(v)
+			return
+		}
+		for _, item := range v {
+			walk(item, fn)
+		}
+	case []any:
+		for _, item := range v {
+			walk(item, fn)
+		}
+	}
+}
+
+func asMap(value any) map[string]any {
+	m, _ := value.(map[string]any)
+	return m
+}
+
+func asSlice(value any) []any {
+	s, _ := value.([]any)
+	return s
+}
+
+// asInt returns the integer of a JSON number, the uint32 wrappers of Envoy may be dumped as numbers or strings.
+func asInt(value any) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		var n int64
+		_, _ = fmt.Sscan(v, &n)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package envoy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const configDump = `{
+  "configs": [
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.RoutesConfigDump",
+      "dynamic_route_configs": [
+        {
+          "route_config": {
+            "name": "ingress_http",
+            "virtual_hosts": [
+              {
+                "name": "demo.example.com",
+                "domains": ["demo.example.com", "demo.example.com:*"],
+                "routes": [
+                  {
+                    "match": {"prefix": "/"},
+                    "route": {
+                      "weighted_clusters": {
+                        "clusters": [
+                          {"name": "default/stable/80/da39a3ee5e", "weight": 70},
+                          {"name": "default/canary/80/da39a3ee5e", "weight": "30"}
+                        ]
+                      }
+                    }
+                  },
+                  {
+                    "match": {"prefix": "/static"},
+                    "route": {"cluster": "default/static/80/da39a3ee5e"}
+                  }
+                ]
+              }
+            ]
+          }
+        }
+      ]
+    }
+  ]
+}`
+
+func TestFetchRoutes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/config_dump" || req.URL.Query().Get("resource") != "dynamic_route_configs" {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(configDump))
+	}))
+	defer server.Close()
+
+	routes, err := FetchRoutes(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("FetchRoutes() error = %v", err)
+	}
+
+	want := []Route{{
+		VirtualHost: "demo.example.com",
+		Domains:     []string{"demo.example.com", "demo.example.com:*"},
+		Clusters: []WeightedCluster{
+			{Name: "default/stable/80/da39a3ee5e", Weight: 70},
+			{Name: "default/canary/80/da39a3ee5e", Weight: 30},
+		},
+	}}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("FetchRoutes() = %+v, want %+v", routes, want)
+	}
+	if !routes[0].HasDomain("demo.example.com") || routes[0].HasDomain("example.com") {
+		t.Error("HasDomain() should only match the domains of the virtual host")
+	}
+}
+
+func TestFetchRoutesFailsOnBadResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := FetchRoutes(context.Background(), server.Client(), server.URL); err == nil {
+		t.Error("FetchRoutes() should fail")
+	}
+	if _, err := ParseRoutes([]byte("not json")); err == nil {
+		t.Error("ParseRoutes() should fail")
+	}
+}
+
+func TestClusterService(t *testing.T) {
+	namespace, service, ok := ClusterService("default/canary/80/da39a3ee5e")
+	if !ok || namespace != "default" || service != "canary" {
+		t.Errorf("ClusterService() = %s, %s, %v", namespace, service, ok)
+	}
+	if _, _, ok := ClusterService("extension/service"); ok {
+		t.Error("ClusterService() should not parse the name")
+	}
+}
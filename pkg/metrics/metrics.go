@@ -0,0 +1,136 @@
+// Package metrics holds the Prometheus metrics of the plugin.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rollouts_plugin_contour"
+
+// The outcomes of the calls of the plugin.
+const (
+	OutcomeSuccess             = "success"
+	OutcomeError               = "error"
+	OutcomeNotVerified         = "not_verified"
+	OutcomeTimeout             = "timeout"
+	OutcomeValidationError     = "validation_error"
+	OutcomeNotReady            = "not_ready"
+	OutcomeRejected            = "rejected"
+	OutcomeVerificationTimeout = "verification_timeout"
+)
+
+var (
+	// Registry holds the metrics of the plugin, it's separate from the default registry so the metrics
+	// of the libraries used by the plugin aren't served.
+	Registry = prometheus.NewRegistry()
+
+	calls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "calls_total",
+		Help:      "The number of calls of the plugin by method and outcome.",
+	}, []string{"method", "outcome"})
+
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "call_duration_seconds",
+		Help:      "The duration of the calls of the plugin by method and outcome.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "outcome"})
+
+	patchConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "patch_conflicts_total",
+		Help:      "The number of httpproxy writes rejected because of a conflict.",
+	})
+
+	desiredCanaryWeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "desired_canary_weight",
+		Help:      "The canary weight last set on the httpproxy.",
+	}, []string{"namespace", "httpproxy"})
+
+	verificationWait = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "verification_wait_seconds",
+		Help:      "How long the current weight of the rollout has been waiting to be verified, 0 once it is verified.",
+	}, []string{"namespace", "rollout"})
+
+	verificationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "verification_duration_seconds",
+		Help:      "The time from the first not verified weight to its verification.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		calls,
+		callDuration,
+		patchConflicts,
+		desiredCanaryWeight,
+		verificationWait,
+		verificationDuration,
+	)
+}
+
+// ObserveCall records a call of the plugin.
+func ObserveCall(method, outcome string, duration time.Duration) {
+	calls.WithLabelValues(method, outcome).Inc()
+	callDuration.WithLabelValues(method, outcome).Observe(duration.Seconds())
+}
+
+// IncPatchConflicts counts a httpproxy write rejected because of a conflict.
+func IncPatchConflicts() {
+	patchConflicts.Inc()
+}
+
+// SetDesiredCanaryWeight records the canary weight set on the httpproxy.
+func SetDesiredCanaryWeight(namespace, httpProxy string, weight int32) {
+	desiredCanaryWeight.WithLabelValues(namespace, httpProxy).Set(float64(weight))
+}
+
+// DeleteDesiredCanaryWeight drops the canary weight of the httpproxy once it isn't managed anymore.
+func DeleteDesiredCanaryWeight(namespace, httpProxy string) {
+	desiredCanaryWeight.DeleteLabelValues(namespace, httpProxy)
+}
+
+// SetVerificationWait records how long the current weight of the rollout has been waiting to be verified.
+func SetVerificationWait(namespace, rollout string, wait time.Duration) {
+	verificationWait.WithLabelValues(namespace, rollout).Set(wait.Seconds())
+}
+
+// DeleteVerificationWait drops the verification wait of the rollout once it's promoted, aborted or deleted.
+func DeleteVerificationWait(namespace, rollout string) {
+	verificationWait.DeleteLabelValues(namespace, rollout)
+}
+
+// ObserveVerified records a weight which has been verified after having waited for it.
+func ObserveVerified(namespace, rollout string, wait time.Duration) {
+	verificationWait.WithLabelValues(namespace, rollout).Set(0)
+	verificationDuration.Observe(wait.Seconds())
+}
+
+// Handler serves the metrics of the Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Serve serves the metrics on /metrics of the address until it fails.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	ObserveCall("SetWeight", OutcomeSuccess, 100*time.Millisecond)
+	ObserveCall("SetWeight", OutcomeSuccess, 200*time.Millisecond)
+	IncPatchConflicts()
+	SetDesiredCanaryWeight("default", "demo", 30)
+	SetVerificationWait("default", "demo", time.Minute)
+
+	if got := testutil.ToFloat64(calls.WithLabelValues("SetWeight", OutcomeSuccess)); got != 2 {
+		t.Errorf("the calls are %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(desiredCanaryWeight.WithLabelValues("default", "demo")); got != 30 {
+		t.Errorf("the desired canary weight is %v, want 30", got)
+	}
+	if got := testutil.ToFloat64(verificationWait.WithLabelValues("default", "demo")); got != 60 {
+		t.Errorf("the verification wait is %v, want 60", got)
+	}
+	ObserveVerified("default", "demo", time.Minute)
+	if got := testutil.ToFloat64(verificationWait.WithLabelValues("default", "demo")); got != 0 {
+		t.Errorf("the verification wait is %v, want 0 once verified", got)
+	}
+
+	DeleteDesiredCanaryWeight("default", "demo")
+	DeleteVerificationWait("default", "demo")
+	if n := testutil.CollectAndCount(desiredCanaryWeight); n != 0 {
+		t.Errorf("%d desired canary weights are left, want 0", n)
+	}
+	if n := testutil.CollectAndCount(verificationWait); n != 0 {
+		t.Errorf("%d verification waits are left, want 0", n)
+	}
+	SetDesiredCanaryWeight("default", "demo", 30)
+	SetVerificationWait("default", "demo", time.Minute)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, name := range []string{
+		"rollouts_plugin_contour_calls_total",
+		"rollouts_plugin_contour_call_duration_seconds",
+		"rollouts_plugin_contour_patch_conflicts_total",
+		"rollouts_plugin_contour_desired_canary_weight",
+		"rollouts_plugin_contour_verification_wait_seconds",
+		"rollouts_plugin_contour_verification_duration_seconds",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("the metric %s is not served", name)
+		}
+	}
+}
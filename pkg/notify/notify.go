@@ -0,0 +1,269 @@
+// Package notify sends the changes of the traffic made by the plugin to HTTP webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The types of the events sent to the webhooks.
+const (
+	// EventWeightChanged is sent when the plugin has changed the canary weight of the httpproxies.
+	EventWeightChanged = "WeightChanged"
+	// EventError is sent when a call of the plugin has failed.
+	EventError = "Error"
+)
+
+const (
+	// DefaultTimeout is the timeout of a request to a webhook when it isn't set.
+	DefaultTimeout = 5 * time.Second
+	// DefaultRetries is the number of times a failed request is retried when it isn't set.
+	DefaultRetries = 3
+
+	queueSize = 100
+)
+
+// retryInterval is the wait before the first retry, it doubles with every retry.
+var retryInterval = time.Second
+
+// Event is a change of the traffic made by the plugin, or an error of a call of the plugin.
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Namespace   string    `json:"namespace"`
+	Rollout     string    `json:"rollout"`
+	Revision    string    `json:"revision,omitempty"`
+	Weight      int32     `json:"weight"`
+	HTTPProxies []string  `json:"httpProxies,omitempty"`
+	// Method is the call of the plugin, e.g. SetWeight
+	Method string `json:"method"`
+	// Error is the error of the call for an EventError
+	Error string `json:"error,omitempty"`
+}
+
+// Webhook is a target of the events.
+type Webhook struct {
+	// Name identifies the webhook in the logs
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Headers are added to the requests, e.g. an Authorization header
+	Headers map[string]string `json:"headers,omitempty"`
+	// Events are the types of the events sent to the webhook, all of them when empty
+	Events []string `json:"events,omitempty"`
+	// Template is a Go text/template of the body executed with the Event, the body is the JSON of the Event when empty
+	Template string `json:"template,omitempty"`
+	// Timeout is the timeout of a request, e.g. 10s, defaults to 5s
+	Timeout string `json:"timeout,omitempty"`
+	// Retries is the number of times a request is retried on a network error or a 5xx response, defaults to 3
+	Retries *int `json:"retries,omitempty"`
+
+	template *template.Template
+	timeout  time.Duration
+}
+
+// Config holds the webhooks.
+type Config struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// LoadConfig reads the YAML or JSON configuration of the webhooks.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the webhook configuration: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse the webhook configuration %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (w *Webhook) init() error {
+	if w.URL == "" {
+		return fmt.Errorf("the url of the webhook %q is missing", w.Name)
+	}
+	for _, eventType := range w.Events {
+		if eventType != EventWeightChanged && eventType != EventError {
+			return fmt.Errorf("the event %q of the webhook %q is unknown", eventType, w.Name)
+		}
+	}
+	if w.Template != "" {
+		tmpl, err := template.New(w.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(w.Template)
+		if err != nil {
+			return fmt.Errorf("the template of the webhook %q is invalid: %w", w.Name, err)
+		}
+		w.template = tmpl
+	}
+	w.timeout = DefaultTimeout
+	if w.Timeout != "" {
+		d, err := time.ParseDuration(w.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("the timeout of the webhook %q must be a positive duration: %q", w.Name, w.Timeout)
+		}
+		w.timeout = d
+	}
+	if w.Retries != nil && *w.Retries < 0 {
+		return fmt.Errorf("the retries of the webhook %q must not be negative", w.Name)
+	}
+	return nil
+}
+
+func (w *Webhook) retries() int {
+	if w.Retries == nil {
+		return DefaultRetries
+	}
+	return *w.Retries
+}
+
+func (w *Webhook) wants(event Event) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event.Type)
+}
+
+// body returns the body of the request of the event.
+func (w *Webhook) body(event Event) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toJSON is the json function of the templates, it quotes the strings.
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Notifier sends the events to the webhooks in the background, so the calls of the plugin aren't slowed down.
+type Notifier struct {
+	webhooks []Webhook
+	client   *http.Client
+	queue    chan Event
+}
+
+// New returns a notifier of the webhooks of the configuration.
+func New(cfg *Config, client *http.Client) (*Notifier, error) {
+	webhooks := make([]Webhook, len(cfg.Webhooks))
+	for i, w := range cfg.Webhooks {
+		if err := w.init(); err != nil {
+			return nil, err
+		}
+		webhooks[i] = w
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Notifier{webhooks: webhooks, client: client, queue: make(chan Event, queueSize)}, nil
+}
+
+// Start sends the queued events until the stop channel is closed.
+func (n *Notifier) Start(stopCh <-chan struct{}) {
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stopCh
+			cancel()
+		}()
+
+		for {
+			select {
+			case event := <-n.queue:
+				n.dispatch(ctx, event)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Notify queues the event, it's dropped when the queue is full.
+func (n *Notifier) Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn("dropping the webhook event, the queue is full", slog.String("type", event.Type), slog.String("rollout", event.Rollout))
+	}
+}
+
+// dispatch sends the event to the webhooks which want it.
+func (n *Notifier) dispatch(ctx context.Context, event Event) {
+	for i := range n.webhooks {
+		w := &n.webhooks[i]
+		if !w.wants(event) {
+			continue
+		}
+		if err := n.deliver(ctx, w, event); err != nil {
+			slog.Warn("failed to send the event to the webhook", slog.String("webhook", w.Name), slog.String("type", event.Type), slog.Any("err", err))
+		}
+	}
+}
+
+// deliver sends the event to the webhook, and retries on a network error or a 5xx response.
+func (n *Notifier) deliver(ctx context.Context, w *Webhook, event Event) error {
+	body, err := w.body(event)
+	if err != nil {
+		return fmt.Errorf("failed to execute the template: %w", err)
+	}
+
+	interval := retryInterval
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, w, body)
+		if err == nil || !retry || attempt >= w.retries() {
+			return err
+		}
+		slog.Debug("retrying the webhook", slog.String("webhook", w.Name), slog.Int("attempt", attempt+1), slog.Any("err", err))
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		interval *= 2
+	}
+}
+
+// post posts the body to the webhook, it reports whether the request may be retried when it fails.
+func (n *Notifier) post(ctx context.Context, w *Webhook, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("the webhook has responded %s", resp.Status)
+	}
+	return false, nil
+}
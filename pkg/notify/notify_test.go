@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a webhook which records the bodies it receives, it fails the first failures requests.
+type recorder struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.bodies = append(rec.bodies, string(body))
+	rec.headers = append(rec.headers, r.Header)
+	if rec.failures > 0 {
+		rec.failures--
+		w.WriteHeader(rec.status)
+	}
+}
+
+func (rec *recorder) received() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string{}, rec.bodies...)
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestDeliver(t *testing.T) {
+	retryInterval = time.Millisecond
+	event := Event{Type: EventWeightChanged, Namespace: "default", Rollout: "demo", Weight: 20, Method: "SetWeight"}
+
+	tests := []struct {
+		name       string
+		webhook    Webhook
+		failures   int
+		status     int
+		wantBodies int
+		wantErr    bool
+	}{
+		{
+			name:       "retried on a 5xx response",
+			webhook:    Webhook{Name: "chatops", Retries: intPtr(2)},
+			failures:   2,
+			status:     http.StatusServiceUnavailable,
+			wantBodies: 3,
+		},
+		{
+			name:       "retries exhausted",
+			webhook:    Webhook{Name: "chatops", Retries: intPtr(1)},
+			failures:   5,
+			status:     http.StatusBadGateway,
+			wantBodies: 2,
+			wantErr:    true,
+		},
+		{
+			name:       "not retried on a 4xx response",
+			webhook:    Webhook{Name: "chatops"},
+			failures:   1,
+			status:     http.StatusBadRequest,
+			wantBodies: 1,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{failures: tt.failures, status: tt.status}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+
+			tt.webhook.URL = server.URL
+			n, err := New(&Config{Webhooks: []Webhook{tt.webhook}}, server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = n.deliver(context.Background(), &n.webhooks[0], event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(rec.received()); got != tt.wantBodies {
+				t.Errorf("the webhook has received %d requests, want %d", got, tt.wantBodies)
+			}
+		})
+	}
+}
+
+func TestNotifierSendsTheWantedEvents(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	n, err := New(&Config{Webhooks: []Webhook{
+		{
+			Name:     "chatops",
+			URL:      server.URL,
+			Headers:  map[string]string{"Authorization": "Bearer token"},
+			Events:   []string{EventError},
+			Template: `{"text": {{ printf "%s/%s failed: %s" .Namespace .Rollout .Error | json }}}`,
+		},
+	}}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	n.Start(stopCh)
+
+	n.Notify(Event{Type: EventWeightChanged, Namespace: "default", Rollout: "demo", Weight: 20})
+	n.Notify(Event{Type: EventError, Namespace: "default", Rollout: "demo", Error: `the "patch" failed`})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	bodies := rec.received()
+	if len(bodies) != 1 {
+		t.Fatalf("the webhook has received %q, want only the error", bodies)
+	}
+	payload := map[string]string{}
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatalf("the body %q is not JSON: %v", bodies[0], err)
+	}
+	if want := `default/demo failed: the "patch" failed`; payload["text"] != want {
+		t.Errorf("the text is %q, want %q", payload["text"], want)
+	}
+	if got := rec.headers[0].Get("Authorization"); got != "Bearer token" {
+		t.Errorf("the Authorization header is %q", got)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "webhooks.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := LoadConfig(write(`
+webhooks:
+  - name: change-management
+    url: https://example.com/hook
+    events: [WeightChanged]
+    timeout: 10s
+    retries: 0
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if _, err := New(cfg, nil); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+	if w := cfg.Webhooks[0]; w.Name != "change-management" || w.Timeout != "10s" || *w.Retries != 0 {
+		t.Errorf("the webhook is %+v", w)
+	}
+
+	if _, err := LoadConfig(write("webhooks:\n  - name: x\n    uri: https://example.com\n")); err == nil {
+		t.Error("LoadConfig() should fail on an unknown field")
+	}
+	for _, w := range []Webhook{
+		{Name: "no url"},
+		{Name: "event", URL: "https://example.com", Events: []string{"Deleted"}},
+		{Name: "template", URL: "https://example.com", Template: "{{ .Rollout"},
+		{Name: "timeout", URL: "https://example.com", Timeout: "soon"},
+	} {
+		if _, err := New(&Config{Webhooks: []Webhook{w}}, nil); err == nil {
+			t.Errorf("New() should fail for the webhook %q", w.Name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// pendingWriteTimeout is how long a write of the plugin is waited for in the cache,
+// after it the cache is trusted again even if the write has never been seen.
+const pendingWriteTimeout = time.Minute
+
+// pendingWrite is a resourceVersion written by the plugin which the cache hasn't seen yet.
+type pendingWrite struct {
+	resourceVersion string
+	at              time.Time
+}
+
+// httpProxyCache is an informer-backed cache of the httpproxies, an informer is started for every
+// namespace the first time a httpproxy of it is read.
+type httpProxyCache struct {
+	client dynamic.Interface
+	resync time.Duration
+	stopCh <-chan struct{}
+	// onChange is called with every added or updated httpproxy, it must be set before the first informer is started
+	onChange func(obj *unstructured.Unstructured)
+
+	mu        sync.Mutex
+	informers map[string]cache.SharedIndexInformer
+	pending   map[types.NamespacedName]pendingWrite
+}
+
+func newHTTPProxyCache(client dynamic.Interface, resync time.Duration, stopCh <-chan struct{}) *httpProxyCache {
+	return &httpProxyCache{
+		client:    client,
+		resync:    resync,
+		stopCh:    stopCh,
+		informers: map[string]cache.SharedIndexInformer{},
+		pending:   map[types.NamespacedName]pendingWrite{},
+	}
+}
+
+// informerFor returns the informer of the namespace, which is started if it's not yet.
+func (c *httpProxyCache) informerFor(namespace string) cache.SharedIndexInformer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if informer, ok := c.informers[namespace]; ok {
+		return informer
+	}
+
+	slog.Info("starting the httpproxy informer", slog.String("namespace", namespace))
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, c.resync, namespace, nil)
+	informer := factory.ForResource(contourv1.HTTPProxyGVR).Informer()
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			c.observe(obj)
+			c.notify(obj)
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			c.observe(oldObj)
+			c.observe(newObj)
+			c.notify(newObj)
+		},
+	})
+	factory.Start(c.stopCh)
+
+	c.informers[namespace] = informer
+	return informer
+}
+
+// get returns the cached httpproxy, it reports false when the httpproxy has to be read from the
+// API server: the informer hasn't synced, the httpproxy isn't cached or the cache hasn't seen
+// the last write of the plugin yet.
+func (c *httpProxyCache) get(namespace, name string) (*contourv1.HTTPProxy, bool) {
+	informer := c.informerFor(namespace)
+	if !informer.HasSynced() {
+		return nil, false
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if c.isPending(key) {
+		return nil, false
+	}
+
+	obj, exists, err := informer.GetIndexer().GetByKey(key.String())
+	if err != nil || !exists {
+		return nil, false
+	}
+	unstr, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+
+	httpProxy, err := convertHTTPProxy(unstr)
+	if err != nil {
+		return nil, false
+	}
+	return httpProxy, true
+}
+
+// recordWrite makes the reads of the httpproxy bypass the cache until it has seen the written resourceVersion.
+func (c *httpProxyCache) recordWrite(httpProxy *contourv1.HTTPProxy) {
+	if httpProxy.ResourceVersion == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[types.NamespacedName{Namespace: httpProxy.Namespace, Name: httpProxy.Name}] = pendingWrite{
+		resourceVersion: httpProxy.ResourceVersion,
+		at:              time.Now(),
+	}
+}
+
+func (c *httpProxyCache) isPending(key types.NamespacedName) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	write, ok := c.pending[key]
+	if !ok {
+		return false
+	}
+	if time.Since(write.at) > pendingWriteTimeout {
+		slog.Debug("the write is not seen by the cache in time", slog.String("name", key.String()), slog.String("resourceVersion", write.resourceVersion))
+		delete(c.pending, key)
+		return false
+	}
+	return true
+}
+
+// observe clears the pending write of the httpproxy once the cache has seen its resourceVersion.
+func (c *httpProxyCache) observe(obj any) {
+	unstr, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: unstr.GetNamespace(), Name: unstr.GetName()}
+	if write, ok := c.pending[key]; ok && write.resourceVersion == unstr.GetResourceVersion() {
+		delete(c.pending, key)
+	}
+}
+
+func (c *httpProxyCache) notify(obj any) {
+	if unstr, ok := obj.(*unstructured.Unstructured); ok && c.onChange != nil {
+		c.onChange(unstr)
+	}
+}
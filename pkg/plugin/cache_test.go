@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newSyncedCache(t *testing.T, r *RpcPlugin) *httpProxyCache {
+	t.Helper()
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	c := newHTTPProxyCache(r.dynamicClient, 0, stopCh)
+	if !cache.WaitForCacheSync(stopCh, c.informerFor("default").HasSynced) {
+		t.Fatal("the cache is not synced")
+	}
+	return c
+}
+
+func TestHTTPProxyCacheServesReads(t *testing.T) {
+	dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+	}
+	r.cache = newSyncedCache(t, r)
+	dynClient.ClearActions()
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	verified, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{})
+	if err.HasError() {
+		t.Fatalf("VerifyWeight() error = %v", err)
+	}
+	if verified != types.Verified {
+		t.Errorf("VerifyWeight() = %v, want %v", verified, types.Verified)
+	}
+
+	for _, action := range dynClient.Actions() {
+		if action.GetVerb() == "get" {
+			t.Errorf("the httpproxy should be read from the cache")
+		}
+	}
+}
+
+func TestHTTPProxyCacheBypassedUntilWriteIsSeen(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	c := newSyncedCache(t, r)
+
+	httpProxy, ok := c.get("default", mocks.HTTPProxyName)
+	if !ok {
+		t.Fatal("the httpproxy should be cached")
+	}
+
+	written := httpProxy.DeepCopy()
+	written.ResourceVersion = "2"
+	c.recordWrite(written)
+	if _, ok := c.get("default", mocks.HTTPProxyName); ok {
+		t.Error("the cache should be bypassed until the write is seen")
+	}
+
+	seen := &unstructured.Unstructured{}
+	seen.SetNamespace("default")
+	seen.SetName(mocks.HTTPProxyName)
+	seen.SetResourceVersion("1")
+	c.observe(seen)
+	if _, ok := c.get("default", mocks.HTTPProxyName); ok {
+		t.Error("the cache should be bypassed until the written resourceVersion is seen")
+	}
+
+	seen.SetResourceVersion("2")
+	c.observe(seen)
+	if _, ok := c.get("default", mocks.HTTPProxyName); !ok {
+		t.Error("the cache should be used once the write is seen")
+	}
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// accessCheck is a permission the plugin needs.
+type accessCheck struct {
+	group     string
+	resource  string
+	verb      string
+	namespace string
+}
+
+// requiredAccess returns the permissions needed by the plugin with its configuration.
+func (r *RpcPlugin) requiredAccess() []accessCheck {
+	checks := []accessCheck{}
+	for _, verb := range []string{"get", "list", "watch", "patch"} {
+		checks = append(checks, accessCheck{group: contourv1.GroupName, resource: contourv1.HTTPProxyGVR.Resource, verb: verb})
+	}
+	// the recorder patches the events it aggregates
+	checks = append(checks, accessCheck{resource: "events", verb: "create"}, accessCheck{resource: "events", verb: "patch"})
+	// the canary readiness is configured by the rollouts
+	checks = append(checks, accessCheck{group: discoveryv1.GroupName, resource: "endpointslices", verb: "list"})
+	// the deleted rollouts are forgotten
+	for _, verb := range []string{"list", "watch"} {
+		checks = append(checks, accessCheck{group: v1alpha1.RolloutGVR.Group, resource: v1alpha1.RolloutGVR.Resource, verb: verb})
+	}
+	if r.EnvoyAdminURL == "" && r.EnvoyPodSelector != "" {
+		checks = append(checks, accessCheck{resource: "pods", verb: "list"})
+	}
+	// the log level is read by an informer of the configmap
+	if namespace, _, ok := strings.Cut(r.LogLevelConfigMap, "/"); ok {
+		for _, verb := range []string{"list", "watch"} {
+			checks = append(checks, accessCheck{resource: "configmaps", verb: verb, namespace: namespace})
+		}
+	}
+	return checks
+}
+
+// diagnose checks that the HTTPProxy CRD is served and that the plugin has the permissions it needs,
+// it logs a summary and returns the problems found.
+func (r *RpcPlugin) diagnose(ctx context.Context) error {
+	var problems []error
+
+	if err := r.checkHTTPProxyServed(); err != nil {
+		problems = append(problems, err)
+	}
+
+	for _, check := range r.requiredAccess() {
+		allowed, err := r.checkAccess(ctx, check)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("failed to check the %s permission on %s: %w", check.verb, check.resource, err))
+			continue
+		}
+		if !allowed {
+			problems = append(problems, fmt.Errorf("the %s permission on %s is missing, see yaml/rbac.yaml", check.verb, check.resource))
+		}
+	}
+
+	if len(problems) == 0 {
+		slog.Info("the diagnostics have passed, the httpproxies are served and the permissions are granted")
+		return nil
+	}
+	for _, problem := range problems {
+		slog.Warn("the diagnostics have found a problem", slog.Any("err", problem))
+	}
+	slog.Warn("the diagnostics have found problems, the weights may not be set", slog.Int("problems", len(problems)))
+	return errors.Join(problems...)
+}
+
+// checkHTTPProxyServed checks with the discovery that the API server serves the HTTPProxies.
+func (r *RpcPlugin) checkHTTPProxyServed() error {
+	groupVersion := contourv1.GroupVersion.String()
+	resources, err := r.kubeClient.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%s is not served, is the HTTPProxy CRD of contour installed?", groupVersion)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to discover %s: %w", groupVersion, err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == contourv1.HTTPProxyGVR.Resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s doesn't serve the httpproxies, is the HTTPProxy CRD of contour installed?", groupVersion)
+}
+
+// checkAccess reports whether the plugin is allowed the check in its namespace, or in all the namespaces.
+func (r *RpcPlugin) checkAccess(ctx context.Context, check accessCheck) (bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: check.namespace,
+				Group:     check.group,
+				Resource:  check.resource,
+				Verb:      check.verb,
+			},
+		},
+	}
+	review, err := r.kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+func Test_diagnose(t *testing.T) {
+	httpProxies := &metav1.APIResourceList{
+		GroupVersion: contourv1.GroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "httpproxies", Kind: "HTTPProxy"}},
+	}
+	tests := []struct {
+		name              string
+		resources         []*metav1.APIResourceList
+		logLevelConfigMap string
+		denied            string
+		wantErr           string
+	}{
+		{
+			name:      "all good",
+			resources: []*metav1.APIResourceList{httpProxies},
+		},
+		{
+			name:    "crd missing",
+			wantErr: "is the HTTPProxy CRD of contour installed?",
+		},
+		{
+			name:      "permission missing",
+			resources: []*metav1.APIResourceList{httpProxies},
+			denied:    "patch httpproxies",
+			wantErr:   "the patch permission on httpproxies is missing",
+		},
+		{
+			name:      "events permission missing",
+			resources: []*metav1.APIResourceList{httpProxies},
+			denied:    "patch events",
+			wantErr:   "the patch permission on events is missing",
+		},
+		{
+			name:      "endpointslices permission missing",
+			resources: []*metav1.APIResourceList{httpProxies},
+			denied:    "list endpointslices",
+			wantErr:   "the list permission on endpointslices is missing",
+		},
+		{
+			name:      "configmaps not checked without the log level configmap",
+			resources: []*metav1.APIResourceList{httpProxies},
+			denied:    "watch configmaps",
+		},
+		{
+			name:              "configmaps permission missing",
+			resources:         []*metav1.APIResourceList{httpProxies},
+			logLevelConfigMap: "argo-rollouts/contour-plugin",
+			denied:            "watch configmaps",
+			wantErr:           "the watch permission on configmaps is missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			kubeClient.Resources = tt.resources
+			kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = attributes.Verb+" "+attributes.Resource != tt.denied
+				return true, review, nil
+			})
+
+			r := &RpcPlugin{kubeClient: kubeClient, LogLevelConfigMap: tt.logLevelConfigMap}
+			err := r.diagnose(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("diagnose() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("diagnose() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_requiredAccessIsGranted(t *testing.T) {
+	manifest, err := os.ReadFile("../../yaml/rbac.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var role rbacv1.ClusterRole
+	clusterRole, _, _ := strings.Cut(string(manifest), "\n---")
+	if err := yaml.Unmarshal([]byte(clusterRole), &role); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &RpcPlugin{EnvoyPodSelector: "app=envoy", LogLevelConfigMap: "argo-rollouts/contour-plugin"}
+	for _, check := range r.requiredAccess() {
+		// the argo rollouts controller is already allowed to watch the configmaps
+		if check.resource == "configmaps" {
+			continue
+		}
+		granted := slices.ContainsFunc(role.Rules, func(rule rbacv1.PolicyRule) bool {
+			return slices.Contains(rule.APIGroups, check.group) && slices.Contains(rule.Resources, check.resource) && slices.Contains(rule.Verbs, check.verb)
+		})
+		if !granted {
+			t.Errorf("the %s permission on %s is checked but not granted by yaml/rbac.yaml", check.verb, check.resource)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// AnnotationPrefix is the prefix of the annotations read and written by the plugin.
+	AnnotationPrefix = "rollouts-plugin-contour.argoproj.io/"
+	// SelfHealAnnotation set to "false" on a httpproxy stops the plugin from restoring its weights
+	// when they are changed by someone else.
+	SelfHealAnnotation = AnnotationPrefix + "self-heal"
+)
+
+// maxHealRetries is the number of times the restore of a httpproxy is retried before it's dropped until
+// the httpproxy changes again.
+const maxHealRetries = 5
+
+// desiredWeight is the last weight set by the plugin on a httpproxy.
+type desiredWeight struct {
+	rollout *v1alpha1.Rollout
+	ctr     *ContourTrafficRouting
+	weight  int32
+}
+
+// driftHealer restores the weights of the httpproxies managed by the plugin when they are changed
+// by someone else during a rollout, e.g. by hand or by a GitOps sync.
+type driftHealer struct {
+	plugin *RpcPlugin
+	watch  *httpProxyCache
+	queue  workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	desired map[types.NamespacedName]desiredWeight
+}
+
+func newDriftHealer(plugin *RpcPlugin, watch *httpProxyCache) *driftHealer {
+	h := &driftHealer{
+		plugin:  plugin,
+		watch:   watch,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "httpproxy-drift"),
+		desired: map[types.NamespacedName]desiredWeight{},
+	}
+	watch.onChange = h.enqueue
+	return h
+}
+
+// run processes the drifted httpproxies until the stop channel is closed.
+func (h *driftHealer) run(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		h.queue.ShutDown()
+	}()
+	for h.processNext(context.Background()) {
+	}
+}
+
+// remember records the weight of the named httpproxies of the rollout, a weight of 0 means the rollout has
+// been promoted or aborted and the httpproxies aren't watched anymore.
+func (h *driftHealer) remember(rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, names []string, canaryWeightPercent int32) {
+	if canaryWeightPercent == 0 {
+		h.forget(rollout, names)
+		return
+	}
+
+	h.mu.Lock()
+	for _, name := range names {
+		h.desired[types.NamespacedName{Namespace: rollout.Namespace, Name: name}] = desiredWeight{
+			rollout: rollout.DeepCopy(),
+			ctr:     ctr,
+			weight:  canaryWeightPercent,
+		}
+	}
+	h.mu.Unlock()
+
+	// the informer of the namespace is started if the httpproxies aren't read from the cache
+	h.watch.informerFor(rollout.Namespace)
+}
+
+// forget stops watching the named httpproxies of the rollout.
+func (h *driftHealer) forget(rollout *v1alpha1.Rollout, names []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range names {
+		delete(h.desired, types.NamespacedName{Namespace: rollout.Namespace, Name: name})
+	}
+}
+
+// forgetKey stops watching the httpproxy.
+func (h *driftHealer) forgetKey(key types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.desired, key)
+}
+
+// forgetRollout stops watching the httpproxies of the rollout.
+func (h *driftHealer) forgetRollout(key types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, desired := range h.desired {
+		if desired.rollout.Namespace == key.Namespace && desired.rollout.Name == key.Name {
+			delete(h.desired, name)
+		}
+	}
+}
+
+func (h *driftHealer) desiredFor(key types.NamespacedName) (desiredWeight, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	desired, ok := h.desired[key]
+	return desired, ok
+}
+
+// enqueue queues the changed httpproxy if it's managed by the plugin.
+func (h *driftHealer) enqueue(obj *unstructured.Unstructured) {
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if _, ok := h.desiredFor(key); ok {
+		h.queue.Add(key)
+	}
+}
+
+func (h *driftHealer) processNext(ctx context.Context) bool {
+	item, shutdown := h.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer h.queue.Done(item)
+
+	key := item.(types.NamespacedName)
+	err := h.heal(ctx, key)
+	switch {
+	case err == nil:
+		h.queue.Forget(key)
+	case apierrors.IsNotFound(err) || errors.Is(err, ErrValidation):
+		// the httpproxy is deleted or can't be handled anymore, it's watched again on the next SetWeight
+		slog.Error("failed to restore the httpproxy weights, it isn't watched anymore", slog.String("httpproxy", key.Name), slog.String("namespace", key.Namespace), slog.Any("err", err))
+		h.forgetKey(key)
+		h.queue.Forget(key)
+	case h.queue.NumRequeues(key) < maxHealRetries:
+		slog.Error("failed to restore the httpproxy weights", slog.String("httpproxy", key.Name), slog.String("namespace", key.Namespace), slog.Any("err", err))
+		h.queue.AddRateLimited(key)
+	default:
+		slog.Error("failed to restore the httpproxy weights, giving up until it changes again", slog.String("httpproxy", key.Name), slog.String("namespace", key.Namespace), slog.Any("err", err))
+		h.queue.Forget(key)
+	}
+	return true
+}
+
+// heal restores the weights of the httpproxy if they differ from the last ones set by the plugin.
+func (h *driftHealer) heal(ctx context.Context, key types.NamespacedName) error {
+	desired, ok := h.desiredFor(key)
+	if !ok {
+		return nil
+	}
+	ctx = withHTTPProxyLogger(withCallLogger(ctx, desired.rollout, slog.Int("weight", int(desired.weight))), key.Name)
+
+	observed, err := h.plugin.fetchHTTPProxy(ctx, key.Namespace, key.Name)
+	if err != nil {
+		return err
+	}
+	if observed.Annotations[SelfHealAnnotation] == "false" {
+		logger(ctx).Debug("the self-healing of the httpproxy is disabled")
+		return nil
+	}
+
+	expected, err := getDesiredHTTPProxy(ctx, observed, desired.rollout, desired.ctr, desired.weight)
+	if err != nil {
+		return err
+	}
+	drifted, err := weightsChanged(observed, expected)
+	if err != nil || !drifted {
+		return err
+	}
+
+	message := fmt.Sprintf("the weights of the httpproxy have drifted from the canary weight %d, restoring them", desired.weight)
+	logger(ctx).Warn(message)
+	h.plugin.recordEvent(desired.rollout, observed, corev1.EventTypeWarning, WeightDriftReason, message)
+
+	_, err = h.plugin.patchHTTPProxy(ctx, observed, func(observed *contourv1.HTTPProxy) (*contourv1.HTTPProxy, error) {
+		expected, err := getDesiredHTTPProxy(ctx, observed, desired.rollout, desired.ctr, desired.weight)
+		if err != nil {
+			return nil, err
+		}
+		h.plugin.recordWeightHistory(ctx, observed, expected, desired.rollout)
+		return expected, nil
+	})
+	return err
+}
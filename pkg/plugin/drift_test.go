@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	fakeDynClient "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestDriftHealer(t *testing.T, r *RpcPlugin) (*driftHealer, *record.FakeRecorder) {
+	t.Helper()
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	h := newDriftHealer(r, newHTTPProxyCache(r.dynamicClient, 0, stopCh))
+	go h.run(stopCh)
+	return h, recorder
+}
+
+// setWeights changes the weights of the canary route of the httpproxy as someone else would do.
+func setWeights(t *testing.T, r *RpcPlugin, name string, stableWeight, canaryWeight int64, annotations map[string]string) {
+	t.Helper()
+	httpProxy := mustGetHTTPProxy(t, r, name)
+	httpProxy.Annotations = annotations
+	httpProxy.Spec.Routes[0].Services[0].Weight = stableWeight
+	httpProxy.Spec.Routes[0].Services[1].Weight = canaryWeight
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(httpProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unstr := &unstructured.Unstructured{Object: obj}
+	if _, err := r.dynamicClient.Resource(contourv1.HTTPProxyGVR).Namespace("default").Update(context.Background(), unstr, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+}
+
+func canaryWeightOf(t *testing.T, r *RpcPlugin, name string) int64 {
+	t.Helper()
+	return mustGetHTTPProxy(t, r, name).Spec.Routes[0].Services[1].Weight
+}
+
+func TestDriftHealerRestoresWeights(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	h, recorder := newTestDriftHealer(t, r)
+	r.healer = h
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+
+	setWeights(t, r, mocks.HTTPProxyName, 50, 50, nil)
+
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return canaryWeightOf(t, r, mocks.HTTPProxyName) == 30, nil
+	})
+	if err != nil {
+		t.Fatalf("the canary weight is not restored, got %d, want 30", canaryWeightOf(t, r, mocks.HTTPProxyName))
+	}
+
+	// the events of the update come first
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.Contains(event, WeightDriftReason) {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no event is emitted for the drift")
+		}
+	}
+}
+
+func TestDriftHealerSkipsIgnoredHTTPProxies(t *testing.T) {
+	tests := []struct {
+		name        string
+		weight      int32
+		annotations map[string]string
+	}{
+		{
+			name:        "self-heal disabled by annotation",
+			weight:      30,
+			annotations: map[string]string{SelfHealAnnotation: "false"},
+		},
+		{
+			name:   "rollout promoted",
+			weight: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			h := newDriftHealer(r, newHTTPProxyCache(r.dynamicClient, 0, stopCh))
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			ctr, err := getContourTrafficRouting(rollout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.remember(rollout, ctr, ctr.HTTPProxies, 30)
+			h.remember(rollout, ctr, ctr.HTTPProxies, tt.weight)
+
+			setWeights(t, r, mocks.HTTPProxyName, 50, 50, tt.annotations)
+			if err := h.heal(context.Background(), types.NamespacedName{Namespace: "default", Name: mocks.HTTPProxyName}); err != nil {
+				t.Fatalf("heal() error = %v", err)
+			}
+
+			if got := canaryWeightOf(t, r, mocks.HTTPProxyName); got != 50 {
+				t.Errorf("the canary weight is %d, want it untouched", got)
+			}
+			if len(recorder.Events) != 0 {
+				t.Errorf("no event should be emitted, got %q", <-recorder.Events)
+			}
+		})
+	}
+}
+
+func TestDriftHealerWaitsForTheStages(t *testing.T) {
+	stagePollInterval = 10 * time.Millisecond
+
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	h, _ := newTestDriftHealer(t, r)
+	r.healer = h
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+		HTTPProxies: []string{mocks.ValidHTTPProxyName},
+		Stages:      []Stage{{Name: "internal", HTTPProxies: []string{mocks.OutdatedHTTPProxyName}, Timeout: "500ms"}},
+	})
+
+	// the httpproxy of the last stage is changed while the first stage is verified
+	touched := make(chan struct{})
+	go func() {
+		defer close(touched)
+		_ = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			return canaryWeightOf(t, r, mocks.OutdatedHTTPProxyName) == 30, nil
+		})
+		setWeights(t, r, mocks.ValidHTTPProxyName, 60, 40, map[string]string{"touched": "true"})
+	}()
+
+	// the outdated httpproxy is never verified, so the first stage times out
+	if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); !IsVerificationTimeoutError(err) {
+		t.Fatalf("SetWeight() error = %v, want a verification timeout", err)
+	}
+	<-touched
+
+	// the healer has not written the weight of the last stage, and the first stage is restored
+	for _, name := range []string{mocks.ValidHTTPProxyName, mocks.OutdatedHTTPProxyName} {
+		if got := canaryWeightOf(t, r, name); got != mocks.HTTPProxyCanaryWeightPercent {
+			t.Errorf("the canary weight of %s is %d, want %d", name, got, mocks.HTTPProxyCanaryWeightPercent)
+		}
+	}
+}
+
+func TestDriftHealerDropsFailedHTTPProxies(t *testing.T) {
+	tests := []struct {
+		name        string
+		prepare     func(t *testing.T, r *RpcPlugin, dynClient *fakeDynClient.FakeDynamicClient)
+		wantWatched bool
+	}{
+		{
+			name: "httpproxy deleted",
+			prepare: func(t *testing.T, r *RpcPlugin, dynClient *fakeDynClient.FakeDynamicClient) {
+				if err := dynClient.Resource(contourv1.HTTPProxyGVR).Namespace("default").Delete(context.Background(), mocks.HTTPProxyName, metav1.DeleteOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "httpproxy invalid",
+			prepare: func(t *testing.T, r *RpcPlugin, dynClient *fakeDynClient.FakeDynamicClient) {
+				setWeights(t, r, mocks.HTTPProxyName, 50, 40, nil)
+			},
+		},
+		{
+			name: "httpproxy unreadable",
+			prepare: func(t *testing.T, r *RpcPlugin, dynClient *fakeDynClient.FakeDynamicClient) {
+				dynClient.PrependReactor("get", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewInternalError(errors.New("unavailable"))
+				})
+			},
+			wantWatched: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: dynClient,
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			r.recorder = record.NewFakeRecorder(10)
+			h := newDriftHealer(r, newHTTPProxyCache(r.dynamicClient, 0, stopCh))
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			ctr, err := getContourTrafficRouting(rollout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.remember(rollout, ctr, ctr.HTTPProxies, 30)
+			tt.prepare(t, r, dynClient)
+
+			key := types.NamespacedName{Namespace: "default", Name: mocks.HTTPProxyName}
+			h.queue.Add(key)
+			for i := 0; i <= maxHealRetries && h.queue.Len()+h.queue.NumRequeues(key) > 0; i++ {
+				h.processNext(context.Background())
+			}
+
+			if h.queue.Len() != 0 || h.queue.NumRequeues(key) != 0 {
+				t.Errorf("the httpproxy is still queued, %d requeues", h.queue.NumRequeues(key))
+			}
+			if _, watched := h.desiredFor(key); watched != tt.wantWatched {
+				t.Errorf("the httpproxy watched = %v, want %v", watched, tt.wantWatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/envoy"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
+)
+
+// DefaultEnvoyAdminPort is the port of the admin endpoint of the discovered Envoy pods. Contour binds it to 127.0.0.1,
+// so it is only reachable on the pod IP when the admin listener is moved to another address.
+const DefaultEnvoyAdminPort = 9001
+
+// The reasons why the weights of the Envoy configuration aren't verified.
+const (
+	ReasonEnvoyConfigUnavailable = "EnvoyConfigUnavailable"
+	ReasonEnvoyRouteMissing      = "EnvoyRouteMissing"
+	ReasonEnvoyWeightMismatch    = "EnvoyWeightMismatch"
+)
+
+// weightSplit is the expected weights of the canary and stable services of a route.
+type weightSplit struct {
+	canary int64
+	stable int64
+}
+
+// matches reports whether the weights, scaled to the total of the split, are within the tolerance of the
+// verification, Envoy may be given scaled weights.
+func (s weightSplit) matches(canary, stable int64, v *Verification) bool {
+	total, envoyTotal := s.canary+s.stable, canary+stable
+	if total == 0 || envoyTotal == 0 {
+		return v.withinTolerance(canary, s.canary) && v.withinTolerance(stable, s.stable)
+	}
+	// the scaled canary weight is canary*total/envoyTotal, both sides are multiplied by envoyTotal to stay exact,
+	// the difference of the stable weight is the opposite one.
+	diff := canary*total - s.canary*envoyTotal
+	tolerance := v.tolerance() * envoyTotal
+	return -tolerance <= diff && diff <= tolerance
+}
+
+// verifiesEnvoy reports whether the weights are verified against the configuration of Envoy too.
+func (r *RpcPlugin) verifiesEnvoy() bool {
+	return r.EnvoyAdminURL != "" || r.EnvoyPodSelector != ""
+}
+
+// envoyAdminURLs returns the admin endpoints of the Envoys, the configured URL or the ones of the selected pods.
+func (r *RpcPlugin) envoyAdminURLs(ctx context.Context) ([]string, error) {
+	if r.EnvoyAdminURL != "" {
+		return []string{r.EnvoyAdminURL}, nil
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	pods, err := r.kubeClient.CoreV1().Pods(r.EnvoyNamespace).List(ctx, metav1.ListOptions{LabelSelector: r.EnvoyPodSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the envoy pods: %w", err)
+	}
+
+	port := r.EnvoyAdminPort
+	if port == 0 {
+		port = DefaultEnvoyAdminPort
+	}
+	urls := []string{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		urls = append(urls, "http://"+net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)))
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no running envoy pod is found in %q with the selector %q", r.EnvoyNamespace, r.EnvoyPodSelector)
+	}
+	return urls, nil
+}
+
+// verifyEnvoy returns the reasons why the weighted clusters of the Envoys don't split the traffic of the
+// httpproxies as expected.
+func (r *RpcPlugin) verifyEnvoy(
+	ctx context.Context,
+	rollout *v1alpha1.Rollout,
+	ctr *ContourTrafficRouting,
+	canaryWeightPercent int32) ([][]verificationReason, error) {
+
+	urls, err := r.envoyAdminURLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	httpProxies := make([]*contourv1.HTTPProxy, len(ctr.HTTPProxies))
+	for i, name := range ctr.HTTPProxies {
+		if httpProxies[i], err = r.getHTTPProxy(ctx, rollout.Namespace, name); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := r.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	reasons := make([][]verificationReason, len(urls))
+	err = utils.ForEach(r.Concurrency, len(urls), func(i int) error {
+		fetchCtx, cancel := r.withTimeout(ctx)
+		defer cancel()
+
+		routes, err := envoy.FetchRoutes(fetchCtx, httpClient, urls[i])
+		if err != nil {
+			logger(ctx).Debug("failed to get the envoy routes", slog.String("url", urls[i]), slog.Any("err", err))
+			for _, httpProxy := range httpProxies {
+				reasons[i] = append(reasons[i], verificationReason{httpProxy: httpProxy.Name, reason: ReasonEnvoyConfigUnavailable, message: err.Error()})
+			}
+			return nil
+		}
+
+		for _, httpProxy := range httpProxies {
+			proxyReasons, err := verifyEnvoyRoutes(ctx, routes, httpProxy, rollout, ctr, canaryWeightPercent)
+			if err != nil {
+				return err
+			}
+			for j := range proxyReasons {
+				proxyReasons[j].message = fmt.Sprintf("%s: %s", urls[i], proxyReasons[j].message)
+			}
+			reasons[i] = append(reasons[i], proxyReasons...)
+		}
+		return nil
+	})
+	return reasons, err
+}
+
+// verifyEnvoyRoutes checks the Envoy routes which send traffic to the canary service of the httpproxy,
+// each of them must split the traffic like one of the canary routes of the httpproxy.
+func verifyEnvoyRoutes(
+	ctx context.Context,
+	routes []envoy.Route,
+	httpProxy *contourv1.HTTPProxy,
+	rollout *v1alpha1.Rollout,
+	ctr *ContourTrafficRouting,
+	canaryWeightPercent int32) ([]verificationReason, error) {
+
+	routeSvcs, err := getRouteServices(ctx, httpProxy, rollout)
+	if err != nil {
+		return nil, err
+	}
+	splits := []weightSplit{}
+	canaryExpected := false
+	for _, rs := range routeSvcs {
+		canaryWeight, stableWeight := utils.CalcWeight(rs.totalWeight, float32(ctr.routeWeight(rs.route, canaryWeightPercent)))
+		splits = append(splits, weightSplit{canary: canaryWeight, stable: stableWeight})
+		canaryExpected = canaryExpected || canaryWeight > 0
+	}
+
+	canarySvcName := rollout.Spec.Strategy.Canary.CanaryService
+	stableSvcName := rollout.Spec.Strategy.Canary.StableService
+
+	reasons := []verificationReason{}
+	found := false
+	for _, route := range routes {
+		// the routes of an included httpproxy may be served by any virtual host
+		if httpProxy.Spec.VirtualHost != nil && !route.HasDomain(httpProxy.Spec.VirtualHost.Fqdn) {
+			continue
+		}
+
+		hasCanary := false
+		var canary, stable int64
+		for _, cluster := range route.Clusters {
+			namespace, service, ok := envoy.ClusterService(cluster.Name)
+			if !ok || namespace != httpProxy.Namespace {
+				continue
+			}
+			switch service {
+			case canarySvcName:
+				hasCanary = true
+				canary += cluster.Weight
+			case stableSvcName:
+				stable += cluster.Weight
+			}
+		}
+		if !hasCanary {
+			continue
+		}
+		found = true
+
+		matched := false
+		for _, split := range splits {
+			matched = matched || split.matches(canary, stable, ctr.Verification)
+		}
+		if !matched {
+			reasons = append(reasons, verificationReason{
+				httpProxy: httpProxy.Name,
+				reason:    ReasonEnvoyWeightMismatch,
+				message:   fmt.Sprintf("the virtual host %s has the weights canary=%d and stable=%d", route.VirtualHost, canary, stable),
+			})
+		}
+	}
+
+	if !found && canaryExpected {
+		reasons = append(reasons, verificationReason{
+			httpProxy: httpProxy.Name,
+			reason:    ReasonEnvoyRouteMissing,
+			message:   fmt.Sprintf("no route sends traffic to the canary service %s", canarySvcName),
+		})
+	}
+	return reasons, nil
+}
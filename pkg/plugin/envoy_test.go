@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newEnvoyAdmin starts a stand-in of the Envoy admin endpoint whose config dump routes the traffic
+// to the stable and canary services with the given weights.
+func newEnvoyAdmin(t *testing.T, stableWeight, canaryWeight int64) *httptest.Server {
+	t.Helper()
+	configDump := fmt.Sprintf(`{"configs": [{"dynamic_route_configs": [{"route_config": {"virtual_hosts": [{
+		"name": "demo.example.com",
+		"domains": ["*"],
+		"routes": [{"route": {"weighted_clusters": {"clusters": [
+			{"name": "default/%s/80/da39a3ee5e", "weight": %d},
+			{"name": "default/%s/80/da39a3ee5e", "weight": %d}
+		]}}}]
+	}]}}]}]}`, mocks.StableServiceName, stableWeight, mocks.CanaryServiceName, canaryWeight)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(configDump))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// verifyWeightReasons returns why the canary weight of the mocks isn't verified, which VerifyWeight doesn't return.
+func verifyWeightReasons(t *testing.T, r *RpcPlugin, rollout *v1alpha1.Rollout) string {
+	t.Helper()
+	ctr, err := getContourTrafficRouting(rollout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.verifyWeight(context.Background(), rollout, ctr, mocks.HTTPProxyCanaryWeightPercent)
+	if !errors.Is(err, ErrNotVerified) {
+		t.Fatalf("verifyWeight() error = %v, want a not verified error", err)
+	}
+	return err.Error()
+}
+
+func TestVerifyWeightWithEnvoyAdminURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		stableWeight int64
+		canaryWeight int64
+		tolerance    int64
+		want         types.RpcVerified
+	}{
+		{
+			name:         "envoy has the weights",
+			stableWeight: 100 - mocks.HTTPProxyCanaryWeightPercent,
+			canaryWeight: mocks.HTTPProxyCanaryWeightPercent,
+			want:         types.Verified,
+		},
+		{
+			name:         "envoy has the scaled weights",
+			stableWeight: 2 * (100 - mocks.HTTPProxyCanaryWeightPercent),
+			canaryWeight: 2 * mocks.HTTPProxyCanaryWeightPercent,
+			want:         types.Verified,
+		},
+		{
+			name:         "envoy has the scaled weights within the tolerance",
+			stableWeight: 2 * (100 - mocks.HTTPProxyCanaryWeightPercent - 1),
+			canaryWeight: 2 * (mocks.HTTPProxyCanaryWeightPercent + 1),
+			tolerance:    1,
+			want:         types.Verified,
+		},
+		{
+			name:         "envoy has the weights beyond the tolerance",
+			stableWeight: 100 - mocks.HTTPProxyCanaryWeightPercent - 2,
+			canaryWeight: mocks.HTTPProxyCanaryWeightPercent + 2,
+			tolerance:    1,
+			want:         types.NotVerified,
+		},
+		{
+			name:         "envoy has the previous weights",
+			stableWeight: 100,
+			canaryWeight: 0,
+			want:         types.NotVerified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+				EnvoyAdminURL: newEnvoyAdmin(t, tt.stableWeight, tt.canaryWeight).URL,
+			}
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+			setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+				HTTPProxies:  []string{mocks.ValidHTTPProxyName},
+				Verification: &Verification{Tolerance: tt.tolerance},
+			})
+			verified, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{})
+			if verified != tt.want || err.HasError() {
+				t.Errorf("VerifyWeight() = %v, %v, want %v", verified, err, tt.want)
+			}
+			if tt.want == types.NotVerified {
+				if err := verifyWeightReasons(t, r, rollout); !strings.Contains(err, ReasonEnvoyWeightMismatch) {
+					t.Errorf("verifyWeight() error = %v, want a %s reason", err, ReasonEnvoyWeightMismatch)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyWeightWithDiscoveredEnvoyPods(t *testing.T) {
+	admin, err := url.Parse(newEnvoyAdmin(t, 50, 50).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(admin.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminPort, _ := strconv.Atoi(port)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy", Namespace: "projectcontour", Labels: map[string]string{"app": "envoy"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: host},
+	}
+	r := &RpcPlugin{
+		IsTest:           true,
+		dynamicClient:    newFakeDynamicClient(mocks.MakeObjects(false)...),
+		kubeClient:       fake.NewSimpleClientset(pod),
+		EnvoyNamespace:   "projectcontour",
+		EnvoyPodSelector: "app=envoy",
+		EnvoyAdminPort:   adminPort,
+	}
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	verified, rpcErr := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{})
+	if verified != types.NotVerified || rpcErr.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want %v", verified, rpcErr, types.NotVerified)
+	}
+	if err := verifyWeightReasons(t, r, rollout); !strings.Contains(err, ReasonEnvoyWeightMismatch) {
+		t.Errorf("verifyWeight() error = %v, want a %s reason", err, ReasonEnvoyWeightMismatch)
+	}
+
+	r.EnvoyPodSelector = "app=other"
+	if verified, rpcErr := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{}); verified != types.NotVerified || !rpcErr.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want an error without envoy pods", verified, rpcErr)
+	}
+}
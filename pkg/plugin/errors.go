@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+)
+
+var (
+	// ErrTimeout prefixes the errors of the requests to the API server which have hit their deadline.
+	ErrTimeout = errors.New("timeout")
+	// ErrValidation prefixes the errors of a rollout or a httpproxy which can't be handled by the plugin.
+	ErrValidation = errors.New("validation failed")
+	// ErrNotVerified prefixes the reasons why the weights of the httpproxies aren't verified.
+	ErrNotVerified = errors.New("weights not verified")
+	// ErrNotReady prefixes the errors of a canary weight held back because the canary isn't ready for it.
+	ErrNotReady = errors.New("canary not ready")
+	// ErrRejected prefixes the errors of the weights which have made contour mark a httpproxy invalid.
+	ErrRejected = errors.New("rejected by contour")
+	// ErrVerificationTimeout prefixes the errors of a weight which hasn't been verified within the verification timeout.
+	ErrVerificationTimeout = errors.New("verification timed out")
+)
+
+// newRpcError returns the RpcError of the error, the error string of a timeout starts with ErrTimeout and
+// the one of a validation failure starts with ErrValidation.
+func newRpcError(err error) pluginTypes.RpcError {
+	if err == nil {
+		return pluginTypes.RpcError{}
+	}
+	if isTimeout(err) {
+		return pluginTypes.RpcError{ErrorString: fmt.Sprintf("%s: %s", ErrTimeout, err)}
+	}
+	return pluginTypes.RpcError{ErrorString: err.Error()}
+}
+
+// IsTimeoutError reports whether the RpcError is caused by a request which has hit its deadline.
+func IsTimeoutError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrTimeout.Error()+":")
+}
+
+// IsValidationError reports whether the RpcError is caused by a rollout or a httpproxy which can't be handled.
+func IsValidationError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrValidation.Error()+":")
+}
+
+// IsNotVerifiedError reports whether the RpcError lists the reasons why the weights aren't verified.
+func IsNotVerifiedError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrNotVerified.Error()+":")
+}
+
+// IsNotReadyError reports whether the RpcError is caused by a canary which isn't ready for the weight.
+func IsNotReadyError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrNotReady.Error()+":")
+}
+
+// IsRejectedError reports whether the RpcError is caused by weights which contour has rejected.
+func IsRejectedError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrRejected.Error()+":")
+}
+
+// IsVerificationTimeoutError reports whether the RpcError is caused by a weight not verified in time.
+func IsVerificationTimeoutError(err pluginTypes.RpcError) bool {
+	return strings.HasPrefix(err.ErrorString, ErrVerificationTimeout.Error()+":")
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err)
+}
+
+// callOutcome returns the outcome of a call of the plugin for the metrics.
+func callOutcome(err pluginTypes.RpcError) string {
+	switch {
+	case !err.HasError():
+		return metrics.OutcomeSuccess
+	case IsNotVerifiedError(err):
+		return metrics.OutcomeNotVerified
+	case IsVerificationTimeoutError(err):
+		return metrics.OutcomeVerificationTimeout
+	case IsTimeoutError(err):
+		return metrics.OutcomeTimeout
+	case IsValidationError(err):
+		return metrics.OutcomeValidationError
+	case IsNotReadyError(err):
+		return metrics.OutcomeNotReady
+	case IsRejectedError(err):
+		return metrics.OutcomeRejected
+	default:
+		return metrics.OutcomeError
+	}
+}
+
+// observeCall records the outcome and the duration of a call of the plugin.
+func observeCall(method string, start time.Time, err *pluginTypes.RpcError) {
+	metrics.ObserveCall(method, callOutcome(*err), time.Since(start))
+}
+
+// observeVerifyCall records the outcome and the duration of a VerifyWeight call, a weight which isn't
+// verified yet isn't an error but has its own outcome.
+func observeVerifyCall(start time.Time, verified *pluginTypes.RpcVerified, err *pluginTypes.RpcError) {
+	outcome := callOutcome(*err)
+	if outcome == metrics.OutcomeSuccess && *verified == pluginTypes.NotVerified {
+		outcome = metrics.OutcomeNotVerified
+	}
+	metrics.ObserveCall("VerifyWeight", outcome, time.Since(start))
+}
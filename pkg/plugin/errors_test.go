@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_newRpcError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantTimeout    bool
+		wantValidation bool
+	}{
+		{
+			name: "no error",
+		},
+		{
+			name:        "deadline exceeded",
+			err:         fmt.Errorf("failed to get the httpproxy: %w", context.DeadlineExceeded),
+			wantTimeout: true,
+		},
+		{
+			name:        "server timeout",
+			err:         apierrors.NewServerTimeout(contourv1.HTTPProxyGVR.GroupResource(), "patch", 1),
+			wantTimeout: true,
+		},
+		{
+			name:           "validation",
+			err:            validateRolloutParameters(nil),
+			wantValidation: true,
+		},
+		{
+			name: "other",
+			err:  apierrors.NewNotFound(contourv1.HTTPProxyGVR.GroupResource(), mocks.HTTPProxyName),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newRpcError(tt.err)
+			if got.HasError() != (tt.err != nil) {
+				t.Errorf("newRpcError() = %q, want error %v", got.ErrorString, tt.err != nil)
+			}
+			if IsTimeoutError(got) != tt.wantTimeout {
+				t.Errorf("IsTimeoutError(%q) = %v, want %v", got.ErrorString, !tt.wantTimeout, tt.wantTimeout)
+			}
+			if IsValidationError(got) != tt.wantValidation {
+				t.Errorf("IsValidationError(%q) = %v, want %v", got.ErrorString, !tt.wantValidation, tt.wantValidation)
+			}
+		})
+	}
+}
+
+func TestSetWeightReportsTimeout(t *testing.T) {
+	dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+	dynClient.PrependReactor("get", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, context.DeadlineExceeded
+	})
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+	}
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	err := r.SetWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	if !IsTimeoutError(err) {
+		t.Errorf("SetWeight() = %q, want a timeout", err.ErrorString)
+	}
+}
+
+func TestSetWeightReportsValidation(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+
+	rollout := newRollout("unknown-stable", mocks.CanaryServiceName, mocks.HTTPProxyName)
+	err := r.SetWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	if !IsValidationError(err) {
+		t.Errorf("SetWeight() = %q, want a validation failure", err.ErrorString)
+	}
+}
+
+func TestCallsReportInvalidRollouts(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+
+	withoutCanary := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	withoutCanary.Spec.Strategy.Canary = nil
+	for name, rollout := range map[string]*v1alpha1.Rollout{"nil rollout": nil, "rollout without canary": withoutCanary} {
+		t.Run(name, func(t *testing.T) {
+			if err := r.SetWeight(rollout, 50, []v1alpha1.WeightDestination{}); !IsValidationError(err) {
+				t.Errorf("SetWeight() = %q, want a validation failure", err.ErrorString)
+			}
+			if _, err := r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{}); !IsValidationError(err) {
+				t.Errorf("VerifyWeight() = %q, want a validation failure", err.ErrorString)
+			}
+			if err := r.RemoveManagedRoutes(rollout); !IsValidationError(err) {
+				t.Errorf("RemoveManagedRoutes() = %q, want a validation failure", err.ErrorString)
+			}
+		})
+	}
+}
+
+func Test_callOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: metrics.OutcomeSuccess},
+		{err: fmt.Errorf("%w: WeightMismatch", ErrNotVerified), want: metrics.OutcomeNotVerified},
+		{err: fmt.Errorf("%w: stuck", ErrVerificationTimeout), want: metrics.OutcomeVerificationTimeout},
+		{err: context.DeadlineExceeded, want: metrics.OutcomeTimeout},
+		{err: validateRolloutParameters(nil), want: metrics.OutcomeValidationError},
+		{err: fmt.Errorf("%w: no endpoint", ErrNotReady), want: metrics.OutcomeNotReady},
+		{err: fmt.Errorf("%w: invalid", ErrRejected), want: metrics.OutcomeRejected},
+		{err: fmt.Errorf("boom"), want: metrics.OutcomeError},
+	}
+	for _, tt := range tests {
+		if got := callOutcome(newRpcError(tt.err)); got != tt.want {
+			t.Errorf("callOutcome(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The reasons of the events emitted on the rollouts and their httpproxies.
+const (
+	// WeightUpdatedReason is the reason of the events emitted when the weights of a httpproxy are changed.
+	WeightUpdatedReason = "WeightUpdated"
+	// WeightUpdateFailedReason is the reason of the events emitted when the weights of a httpproxy can't be changed.
+	WeightUpdateFailedReason = "WeightUpdateFailed"
+	// WeightsRestoredReason is the reason of the events emitted when the weights of a httpproxy are restored
+	// because the update of the rollout's httpproxies has failed.
+	WeightsRestoredReason = "WeightsRestored"
+	// WeightsRestoreFailedReason is the reason of the events emitted on a rollout when the weights of some of
+	// its httpproxies can't be restored.
+	WeightsRestoreFailedReason = "WeightsRestoreFailed"
+	// WeightDriftReason is the reason of the events emitted when the weights of a httpproxy have drifted.
+	WeightDriftReason = "WeightDrift"
+	// VerificationStalledReason is the reason of the events emitted when a weight isn't verified for long.
+	VerificationStalledReason = "VerificationStalled"
+	// WeightNotVerifiedReason is the reason of the events emitted on a rollout when the reasons why its weight
+	// isn't verified yet change.
+	WeightNotVerifiedReason = "WeightNotVerified"
+)
+
+// DefaultVerificationStall is how long a weight may stay not verified before a VerificationStalled
+// event is emitted, when the rollout has no verification timeout.
+const DefaultVerificationStall = time.Minute
+
+// recordEvent emits the event on the rollout and on the httpproxy, the message on the rollout names the httpproxy.
+// Either of them may be nil. Nothing is emitted without a recorder, e.g. in the tests.
+func (r *RpcPlugin) recordEvent(rollout *v1alpha1.Rollout, httpProxy *contourv1.HTTPProxy, eventType, reason, message string) {
+	if r.recorder == nil {
+		return
+	}
+	if httpProxy != nil {
+		r.recorder.Event(httpProxy, eventType, reason, message)
+		message = fmt.Sprintf("httpproxy %s: %s", httpProxy.Name, message)
+	}
+	if rollout != nil {
+		r.recorder.Event(rollout, eventType, reason, message)
+	}
+}
+
+// recordVerificationStalled emits a VerificationStalled event on the rollout and on its httpproxies.
+func (r *RpcPlugin) recordVerificationStalled(ctx context.Context, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32, err error) {
+	if r.recorder == nil {
+		return
+	}
+	message := fmt.Sprintf("the canary weight %d is not verified: %v", canaryWeightPercent, err)
+	r.recordEvent(rollout, nil, corev1.EventTypeWarning, VerificationStalledReason, message)
+	for _, name := range ctr.HTTPProxies {
+		httpProxy, err := r.getHTTPProxy(ctx, rollout.Namespace, name)
+		if err != nil {
+			logger(ctx).Warn("failed to get the httpproxy of the event", slog.String("httpproxy", name), slog.Any("err", err))
+			continue
+		}
+		r.recorder.Event(httpProxy, corev1.EventTypeWarning, VerificationStalledReason, message)
+	}
+}
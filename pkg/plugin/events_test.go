@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// drainEvents returns the events emitted so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSetWeightEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		patchErr   error
+		wantReason string
+	}{
+		{
+			name:       "weights updated",
+			wantReason: "Normal " + WeightUpdatedReason,
+		},
+		{
+			name:       "patch failed",
+			patchErr:   errors.New("the patch failed"),
+			wantReason: "Warning " + WeightUpdateFailedReason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			if tt.patchErr != nil {
+				dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.patchErr
+				})
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: dynClient,
+				recorder:      recorder,
+			}
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+
+			// one event on the httpproxy, and one naming it on the rollout
+			events := drainEvents(recorder)
+			if len(events) != 2 {
+				t.Fatalf("the events are %q, want 2", events)
+			}
+			for _, event := range events {
+				if !strings.HasPrefix(event, tt.wantReason) {
+					t.Errorf("the event is %q, want a %q event", event, tt.wantReason)
+				}
+			}
+			if want := "httpproxy " + mocks.HTTPProxyName + ": "; !strings.Contains(events[1], want) {
+				t.Errorf("the event of the rollout is %q, want it to name the httpproxy", events[1])
+			}
+
+			// the weights are already set, nothing changes
+			if tt.patchErr == nil {
+				r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+				if events := drainEvents(recorder); len(events) != 0 {
+					t.Errorf("the events are %q, want none", events)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyWeightStalledEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+		recorder:      recorder,
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	key := k8stypes.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}
+
+	r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	if events := drainEvents(recorder); len(events) != 1 || !strings.HasPrefix(events[0], "Normal "+WeightNotVerifiedReason) {
+		t.Fatalf("the events are %q, want only the reasons before the weight stalls", events)
+	}
+
+	r.verificationsMu.Lock()
+	pending := r.verifications[key]
+	pending.since = pending.since.Add(-DefaultVerificationStall - time.Second)
+	r.verifications[key] = pending
+	r.verificationsMu.Unlock()
+
+	r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	events := drainEvents(recorder)
+	if len(events) != 2 {
+		t.Fatalf("the events are %q, want one on the rollout and one on the httpproxy", events)
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(event, "Warning "+VerificationStalledReason) {
+			t.Errorf("the event is %q, want a %s event", event, VerificationStalledReason)
+		}
+	}
+
+	// the stall of a weight is reported once
+	r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("the events are %q, want none", events)
+	}
+}
+
+func TestVerifyWeightNotVerifiedEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+		recorder:      recorder,
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+
+	verify := func(weight int32, want int) {
+		t.Helper()
+		if verified, err := r.VerifyWeight(rollout, weight, []v1alpha1.WeightDestination{}); verified != types.NotVerified || err.HasError() {
+			t.Fatalf("VerifyWeight() = %v, %v, want not verified", verified, err)
+		}
+		events := drainEvents(recorder)
+		if len(events) != want {
+			t.Fatalf("the events are %q, want %d", events, want)
+		}
+		for _, event := range events {
+			if !strings.HasPrefix(event, "Normal "+WeightNotVerifiedReason) || !strings.Contains(event, ReasonWeightMismatch) {
+				t.Errorf("the event is %q, want a %s event with the reasons", event, WeightNotVerifiedReason)
+			}
+		}
+	}
+
+	verify(30, 1)
+	// the same reasons are emitted once
+	verify(30, 0)
+	// the reasons of another weight are emitted again
+	verify(60, 1)
+}
+
+func TestSetWeightRollbackEvents(t *testing.T) {
+	dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+	patched := map[string]int{}
+	dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.PatchAction).GetName()
+		patched[name]++
+		switch {
+		case name == mocks.ValidHTTPProxyName:
+			return true, nil, errors.New("the patch failed")
+		case name == mocks.OutdatedHTTPProxyName && patched[name] > 1:
+			return true, nil, errors.New("the restore failed")
+		}
+		return false, nil, nil
+	})
+	recorder := record.NewFakeRecorder(20)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+		recorder:      recorder,
+		Concurrency:   1,
+	}
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName, mocks.OutdatedHTTPProxyName, mocks.ValidHTTPProxyName)
+	if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); !err.HasError() {
+		t.Fatal("SetWeight() should fail")
+	}
+
+	restored, restoreFailed := []string{}, []string{}
+	for _, event := range drainEvents(recorder) {
+		switch {
+		case strings.HasPrefix(event, "Warning "+WeightsRestoredReason+" "):
+			restored = append(restored, event)
+		case strings.HasPrefix(event, "Warning "+WeightsRestoreFailedReason+" "):
+			restoreFailed = append(restoreFailed, event)
+		}
+	}
+	// only the restored httpproxy has events, on itself and on the rollout
+	if len(restored) != 2 || !strings.Contains(restored[1], "httpproxy "+mocks.HTTPProxyName+": ") {
+		t.Errorf("the %s events are %q, want 2 of %s", WeightsRestoredReason, restored, mocks.HTTPProxyName)
+	}
+	if len(restoreFailed) != 1 || !strings.Contains(restoreFailed[0], mocks.OutdatedHTTPProxyName) {
+		t.Errorf("the %s events are %q, want 1 listing %s", WeightsRestoreFailedReason, restoreFailed, mocks.OutdatedHTTPProxyName)
+	}
+}
+
+func TestSetWeightRollbackSkipsUnchangedHTTPProxies(t *testing.T) {
+	dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+	failPatch := false
+	dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failPatch && action.(k8stesting.PatchAction).GetName() == mocks.ValidHTTPProxyName {
+			return true, nil, errors.New("the patch failed")
+		}
+		return false, nil, nil
+	})
+	recorder := record.NewFakeRecorder(20)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+		recorder:      recorder,
+		Concurrency:   1,
+	}
+	valid := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	if err := r.SetWeight(valid, 30, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	drainEvents(recorder)
+
+	// the first httpproxy already has the weights, so it isn't changed and isn't restored
+	failPatch = true
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName, mocks.ValidHTTPProxyName)
+	if err := r.SetWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{}); !err.HasError() {
+		t.Fatal("SetWeight() should fail")
+	}
+	for _, event := range drainEvents(recorder) {
+		if strings.HasPrefix(event, "Warning "+WeightsRestoredReason+" ") {
+			t.Errorf("the event is %q, want no restore of an unchanged httpproxy", event)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// WeightHistoryAnnotation holds the last changes of the weights of a httpproxy made by the plugin,
+// as a JSON list of WeightHistoryEntry, the oldest first.
+const WeightHistoryAnnotation = AnnotationPrefix + "weight-history"
+
+// WeightHistoryEntry is a change of the weights of a httpproxy.
+type WeightHistoryEntry struct {
+	// Time is when the weights were changed
+	Time metav1.Time `json:"time"`
+	// Rollout is the name of the rollout which has changed the weights
+	Rollout string `json:"rollout"`
+	// Revision is the pod template hash of the canary
+	Revision string `json:"revision,omitempty"`
+	// Routes are the changed routes
+	Routes []RouteWeightChange `json:"routes"`
+}
+
+// RouteWeightChange is a change of the weights of the services of a route.
+type RouteWeightChange struct {
+	// Route is the index of the route in the httpproxy
+	Route    int                   `json:"route"`
+	Services []ServiceWeightChange `json:"services"`
+}
+
+// ServiceWeightChange is a change of the weight of a service.
+type ServiceWeightChange struct {
+	Name      string `json:"name"`
+	OldWeight int64  `json:"oldWeight"`
+	NewWeight int64  `json:"newWeight"`
+}
+
+// now is the clock of the history, replaced by the tests.
+var now = time.Now
+
+// setRevision remembers the canary revision of the rollout for the history.
+func (r *RpcPlugin) setRevision(rollout *v1alpha1.Rollout, canaryHash string) {
+	r.revisionsMu.Lock()
+	defer r.revisionsMu.Unlock()
+	if r.revisions == nil {
+		r.revisions = map[types.NamespacedName]string{}
+	}
+	r.revisions[types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}] = canaryHash
+}
+
+// revision returns the canary revision of the rollout, the current pod hash of the rollout
+// until UpdateHash has been called, e.g. after a restart of the plugin.
+func (r *RpcPlugin) revision(rollout *v1alpha1.Rollout) string {
+	r.revisionsMu.Lock()
+	defer r.revisionsMu.Unlock()
+	if revision, ok := r.revisions[types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}]; ok {
+		return revision
+	}
+	return rollout.Status.CurrentPodHash
+}
+
+// recordWeightHistory adds the change of the weights from the observed httpproxy to the desired one to the
+// history annotation of the desired httpproxy, keeping the last WeightHistory changes. Nothing is recorded
+// when the history is disabled or the weights don't change.
+func (r *RpcPlugin) recordWeightHistory(ctx context.Context, observed, desired *contourv1.HTTPProxy, rollout *v1alpha1.Rollout) {
+	if r.WeightHistory <= 0 {
+		return
+	}
+
+	entry := WeightHistoryEntry{
+		Time:     metav1.NewTime(now()),
+		Rollout:  rollout.Name,
+		Revision: r.revision(rollout),
+	}
+	for i := range desired.Spec.Routes {
+		if i >= len(observed.Spec.Routes) || len(observed.Spec.Routes[i].Services) != len(desired.Spec.Routes[i].Services) {
+			// the write fails on the changed routes
+			return
+		}
+		change := RouteWeightChange{Route: i}
+		for j, svc := range desired.Spec.Routes[i].Services {
+			if oldWeight := observed.Spec.Routes[i].Services[j].Weight; oldWeight != svc.Weight {
+				change.Services = append(change.Services, ServiceWeightChange{Name: svc.Name, OldWeight: oldWeight, NewWeight: svc.Weight})
+			}
+		}
+		if len(change.Services) > 0 {
+			entry.Routes = append(entry.Routes, change)
+		}
+	}
+	if len(entry.Routes) == 0 {
+		return
+	}
+
+	history := weightHistory(ctx, observed)
+	history = append(history, entry)
+	if len(history) > r.WeightHistory {
+		history = history[len(history)-r.WeightHistory:]
+	}
+
+	value, err := json.Marshal(history)
+	if err != nil {
+		logger(ctx).Warn("failed to marshal the weight history", slog.Any("err", err))
+		return
+	}
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[WeightHistoryAnnotation] = string(value)
+}
+
+// weightHistory returns the history of the httpproxy, an invalid history is dropped.
+func weightHistory(ctx context.Context, httpProxy *contourv1.HTTPProxy) []WeightHistoryEntry {
+	value, ok := httpProxy.Annotations[WeightHistoryAnnotation]
+	if !ok {
+		return nil
+	}
+	history := []WeightHistoryEntry{}
+	if err := json.Unmarshal([]byte(value), &history); err != nil {
+		logger(ctx).Warn("dropping the invalid weight history", slog.Any("err", err))
+		return nil
+	}
+	return history
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+)
+
+func TestSetWeightRecordsWeightHistory(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 14, 2, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+		WeightHistory: 2,
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	rollout.Status.CurrentPodHash = "stale"
+	r.UpdateHash(rollout, "abc123", "def456", []v1alpha1.WeightDestination{})
+
+	for _, weight := range []int32{30, 50, 50, 70} {
+		if err := r.SetWeight(rollout, weight, []v1alpha1.WeightDestination{}); err.HasError() {
+			t.Fatalf("SetWeight(%d) error = %v", weight, err)
+		}
+		clock = clock.Add(time.Minute)
+	}
+
+	history := weightHistory(context.Background(), mustGetHTTPProxy(t, r, mocks.HTTPProxyName))
+	// the unchanged weight isn't recorded, and only the last 2 changes are kept
+	if len(history) != 2 {
+		t.Fatalf("the history is %+v, want 2 entries", history)
+	}
+	last := history[1]
+	if last.Rollout != rollout.Name || last.Revision != "abc123" {
+		t.Errorf("the last entry is for %s@%s, want %s@abc123", last.Rollout, last.Revision, rollout.Name)
+	}
+	if want := time.Date(2024, 5, 1, 14, 5, 0, 0, time.UTC); !last.Time.Time.Equal(want) {
+		t.Errorf("the last entry is at %s, want %s", last.Time, want)
+	}
+
+	want := []ServiceWeightChange{
+		{Name: mocks.StableServiceName, OldWeight: 50, NewWeight: 30},
+		{Name: mocks.CanaryServiceName, OldWeight: 50, NewWeight: 70},
+	}
+	if len(last.Routes) != 1 || last.Routes[0].Route != 0 || len(last.Routes[0].Services) != len(want) {
+		t.Fatalf("the changed routes are %+v, want the route 0 with %+v", last.Routes, want)
+	}
+	for i, svc := range last.Routes[0].Services {
+		if svc != want[i] {
+			t.Errorf("the change of the service %d is %+v, want %+v", i, svc, want[i])
+		}
+	}
+}
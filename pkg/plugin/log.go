@@ -0,0 +1,27 @@
+package plugin
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
+)
+
+// withCallLogger returns the context of a call of the plugin, it carries a logger with the attributes
+// of the rollout and the given ones.
+func withCallLogger(ctx context.Context, rollout *v1alpha1.Rollout, attrs ...any) context.Context {
+	l := logger(ctx).With(slog.String("rollout", rollout.Name), slog.String("namespace", rollout.Namespace))
+	return utils.WithLogger(ctx, l.With(attrs...))
+}
+
+// withHTTPProxyLogger returns a copy of the context whose logger has the name of the httpproxy.
+func withHTTPProxyLogger(ctx context.Context, name string) context.Context {
+	return utils.WithLogger(ctx, logger(ctx).With(slog.String("httpproxy", name)))
+}
+
+// logger returns the logger of the call, or the default logger outside of a call.
+func logger(ctx context.Context) *slog.Logger {
+	return utils.LoggerFrom(ctx)
+}
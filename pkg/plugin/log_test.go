@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+)
+
+func TestSetWeightLogsCallAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) == 0 {
+		t.Fatal("nothing is logged")
+	}
+	for _, line := range lines {
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("the log line %q is not JSON: %v", line, err)
+		}
+		if record["rollout"] != rollout.Name || record["namespace"] != rollout.Namespace || record["weight"] != float64(30) {
+			t.Errorf("the log line %q doesn't have the attributes of the call", line)
+		}
+		if strings.Contains(record["msg"].(string), "httpproxy") && record["httpproxy"] != mocks.HTTPProxyName {
+			t.Errorf("the log line %q doesn't have the httpproxy", line)
+		}
+	}
+}
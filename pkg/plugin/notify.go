@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/notify"
+)
+
+// notifyWeightChanged sends a WeightChanged event to the webhooks.
+func (r *RpcPlugin) notifyWeightChanged(rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32) {
+	if r.Notifier == nil {
+		return
+	}
+	r.Notifier.Notify(notify.Event{
+		Type:        notify.EventWeightChanged,
+		Namespace:   rollout.Namespace,
+		Rollout:     rollout.Name,
+		Revision:    r.revision(rollout),
+		Weight:      canaryWeightPercent,
+		HTTPProxies: ctr.HTTPProxies,
+		Method:      "SetWeight",
+	})
+}
+
+// notifiedCall is a call of the plugin for a rollout.
+type notifiedCall struct {
+	rollout types.NamespacedName
+	method  string
+}
+
+// notifiedError is the Error event last sent for a call.
+type notifiedError struct {
+	weight int32
+	err    string
+}
+
+// notifyError sends an Error event of the call to the webhooks. The controller calls the plugin again on every
+// reconciliation, so the same error of a weight is only sent once until the call succeeds. The errors of a nil
+// rollout aren't sent, and those of an invalid configuration, without ctr, have no httpproxies.
+func (r *RpcPlugin) notifyError(method string, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32, rpcErr pluginTypes.RpcError) {
+	if r.Notifier == nil || rollout == nil {
+		return
+	}
+
+	call := notifiedCall{rollout: types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}, method: method}
+	notified := notifiedError{weight: canaryWeightPercent, err: rpcErr.Error()}
+	r.notifiedMu.Lock()
+	if !rpcErr.HasError() {
+		delete(r.notified, call)
+		r.notifiedMu.Unlock()
+		return
+	}
+	if last, ok := r.notified[call]; ok && last == notified {
+		r.notifiedMu.Unlock()
+		return
+	}
+	if r.notified == nil {
+		r.notified = map[notifiedCall]notifiedError{}
+	}
+	r.notified[call] = notified
+	r.notifiedMu.Unlock()
+
+	var httpProxies []string
+	if ctr != nil {
+		httpProxies = ctr.HTTPProxies
+	}
+	r.Notifier.Notify(notify.Event{
+		Type:        notify.EventError,
+		Namespace:   rollout.Namespace,
+		Rollout:     rollout.Name,
+		Revision:    r.revision(rollout),
+		Weight:      canaryWeightPercent,
+		HTTPProxies: httpProxies,
+		Method:      method,
+		Error:       rpcErr.Error(),
+	})
+}
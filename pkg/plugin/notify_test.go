@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/notify"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+)
+
+func TestSetWeightNotifiesWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	events := []notify.Event{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		event := notify.Event{}
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("the body is not an event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	notifier, err := notify.New(&notify.Config{Webhooks: []notify.Webhook{{Name: "test", URL: server.URL}}}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	notifier.Start(stopCh)
+
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+		Notifier:      notifier,
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	r.UpdateHash(rollout, "abc123", "def456", []v1alpha1.WeightDestination{})
+
+	r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	// nothing changes, and a weight not verified yet isn't an error
+	r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	r.VerifyWeight(rollout, 40, []v1alpha1.WeightDestination{})
+	// the httpproxy doesn't exist, the same error is sent once for the weight and the method
+	missing := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, "missing")
+	r.SetWeight(missing, 30, []v1alpha1.WeightDestination{})
+	r.SetWeight(missing, 30, []v1alpha1.WeightDestination{})
+	r.SetWeight(missing, 40, []v1alpha1.WeightDestination{})
+	r.VerifyWeight(missing, 40, []v1alpha1.WeightDestination{})
+	// the error is sent again once the call has succeeded
+	r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	r.SetWeight(missing, 40, []v1alpha1.WeightDestination{})
+	// the rollout is invalid
+	r.SetWeight(nil, 30, []v1alpha1.WeightDestination{})
+	r.VerifyWeight(nil, 30, []v1alpha1.WeightDestination{})
+	withoutCanary := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	withoutCanary.Spec.Strategy.Canary = nil
+	r.SetWeight(withoutCanary, 30, []v1alpha1.WeightDestination{})
+
+	want := []notify.Event{
+		{Type: notify.EventWeightChanged, Method: "SetWeight", Weight: 30, Revision: "abc123"},
+		{Type: notify.EventError, Method: "SetWeight", Weight: 30},
+		{Type: notify.EventError, Method: "SetWeight", Weight: 40},
+		{Type: notify.EventError, Method: "VerifyWeight", Weight: 40},
+		{Type: notify.EventError, Method: "SetWeight", Weight: 40},
+		{Type: notify.EventError, Method: "SetWeight", Weight: 30},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n >= len(want) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) {
+		t.Fatalf("the events are %+v, want %d", events, len(want))
+	}
+	for i, e := range events {
+		w := want[i]
+		if e.Type != w.Type || e.Method != w.Method || e.Weight != w.Weight || e.Rollout != rollout.Name {
+			t.Errorf("the event %d is %+v, want a %s of %s at the weight %d", i, e, w.Type, w.Method, w.Weight)
+		}
+		if w.Revision != "" && e.Revision != w.Revision {
+			t.Errorf("the event %d is of the revision %q, want %q", i, e.Revision, w.Revision)
+		}
+		if e.Type == notify.EventError && e.Error == "" {
+			t.Errorf("the event %d has no error", i)
+		}
+	}
+	// the configuration is invalid, so the httpproxies are unknown
+	if e := events[len(events)-1]; len(e.HTTPProxies) != 0 || !strings.HasPrefix(e.Error, ErrValidation.Error()) {
+		t.Errorf("the last event is %+v, want the validation error without httpproxies", e)
+	}
+}
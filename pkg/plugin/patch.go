@@ -0,0 +1,331 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/tracing"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
+)
+
+// DefaultFieldManager is the name of the manager of the fields written by the plugin.
+const DefaultFieldManager = "rollouts-plugin-contour"
+
+// patchOperation is a RFC 6902 JSON patch operation.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// getDesiredHTTPProxy returns a copy of the httpproxy with the weights of the canary and stable services
+// set to the rollout's weight.
+func getDesiredHTTPProxy(ctx context.Context, httpProxy *contourv1.HTTPProxy, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32) (*contourv1.HTTPProxy, error) {
+	desired := httpProxy.DeepCopy()
+	routeSvcs, err := getRouteServices(ctx, desired, rollout)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rs := range routeSvcs {
+		weight := ctr.routeWeight(rs.route, canaryWeightPercent)
+		logger(ctx).Debug("old weight", slog.Int64("canary", rs.canary.Weight), slog.Int64("stable", rs.stable.Weight))
+		rs.canary.Weight, rs.stable.Weight = utils.CalcWeight(rs.totalWeight, float32(weight))
+		logger(ctx).Debug("new weight", slog.Int64("canary", rs.canary.Weight), slog.Int64("stable", rs.stable.Weight))
+	}
+	return desired, nil
+}
+
+// patchHTTPProxy writes the weights of the httpproxy returned by desiredFn for the observed one. On a conflict
+// the httpproxy is read again, as the patch is only valid for the resourceVersion it was created from.
+func (r *RpcPlugin) patchHTTPProxy(
+	ctx context.Context,
+	observed *contourv1.HTTPProxy,
+	desiredFn func(observed *contourv1.HTTPProxy) (*contourv1.HTTPProxy, error)) (*contourv1.HTTPProxy, error) {
+
+	namespace, name := observed.Namespace, observed.Name
+
+	// the attempts are counted here, wait.ExponentialBackoff would end the retries once the wait reaches the cap
+	backoff := r.conflictBackoff()
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			var err error
+			if observed, err = r.fetchHTTPProxy(ctx, namespace, name); err != nil {
+				return nil, err
+			}
+		}
+
+		desired, err := desiredFn(observed)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := r.writeHTTPProxy(ctx, observed, desired)
+		if !isConflict(err) {
+			return updated, err
+		}
+		metrics.IncPatchConflicts()
+		logger(ctx).Warn("the httpproxy has been changed since it was read",
+			slog.String("resourceVersion", observed.ResourceVersion),
+			slog.Int("attempt", attempt),
+			slog.Int64("conflicts", r.conflicts.Add(1)))
+		if attempt > r.ConflictRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// restoreHTTPProxy sets the weights of the httpproxy back to the ones of the snapshot, it reports whether
+// they were changed.
+func (r *RpcPlugin) restoreHTTPProxy(ctx context.Context, rollout *v1alpha1.Rollout, snapshot *contourv1.HTTPProxy) (bool, error) {
+	current, err := r.fetchHTTPProxy(ctx, snapshot.Namespace, snapshot.Name)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	_, err = r.patchHTTPProxy(ctx, current, func(observed *contourv1.HTTPProxy) (*contourv1.HTTPProxy, error) {
+		desired, err := copyWeights(observed, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if changed, err = weightsChanged(observed, desired); err != nil {
+			return nil, err
+		}
+		r.recordWeightHistory(ctx, observed, desired, rollout)
+		return desired, nil
+	})
+	return changed && err == nil, err
+}
+
+// rollbackHTTPProxies restores the httpproxies to their snapshots in the reverse order of their update,
+// it returns the snapshots of the httpproxies whose weights are restored and the names of the ones
+// which can't be restored.
+func (r *RpcPlugin) rollbackHTTPProxies(ctx context.Context, rollout *v1alpha1.Rollout, snapshots []*contourv1.HTTPProxy) (restored []*contourv1.HTTPProxy, failed []string, _ error) {
+	var errs []error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		ctx := withHTTPProxyLogger(ctx, snapshot.Name)
+
+		changed, err := r.restoreHTTPProxy(ctx, rollout, snapshot)
+		if err != nil {
+			logger(ctx).Error("failed to restore httpproxy", slog.Any("err", err))
+			errs = append(errs, fmt.Errorf("failed to restore the httpproxy %s: %w", snapshot.Name, err))
+			failed = append(failed, snapshot.Name)
+			continue
+		}
+		if changed {
+			logger(ctx).Info("restored httpproxy weight")
+			restored = append(restored, snapshot)
+		}
+	}
+	return restored, failed, errors.Join(errs...)
+}
+
+// copyWeights returns a copy of the observed httpproxy with the services' weights of the source.
+func copyWeights(observed, source *contourv1.HTTPProxy) (*contourv1.HTTPProxy, error) {
+	if _, err := weightsChanged(observed, source); err != nil {
+		return nil, err
+	}
+
+	desired := observed.DeepCopy()
+	for i := range desired.Spec.Routes {
+		for j := range desired.Spec.Routes[i].Services {
+			desired.Spec.Routes[i].Services[j].Weight = source.Spec.Routes[i].Services[j].Weight
+		}
+	}
+	return desired, nil
+}
+
+// writeHTTPProxy changes the services' weights of the observed httpproxy to the desired ones,
+// it returns the observed httpproxy when the weights are already the desired ones.
+func (r *RpcPlugin) writeHTTPProxy(ctx context.Context, observed, desired *contourv1.HTTPProxy) (_ *contourv1.HTTPProxy, err error) {
+	ctx, span := tracing.Start(ctx, "writeHTTPProxy",
+		tracing.NamespaceKey.String(observed.Namespace),
+		tracing.HTTPProxyKey.String(observed.Name))
+	defer func() { tracing.End(span, err) }()
+
+	changed, err := weightsChanged(observed, desired)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		logger(ctx).Debug("the httpproxy already has the desired weights")
+		return observed, nil
+	}
+
+	client := r.dynamicClient.Resource(contourv1.HTTPProxyGVR).Namespace(observed.Namespace)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	patchData, patchType, err := createPatch(observed, desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch : %w", err)
+	}
+	unstr, err := client.Patch(ctx, observed.Name, patchType, patchData, metav1.PatchOptions{FieldManager: r.fieldManager()})
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := convertHTTPProxy(unstr)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.recordWrite(updated)
+	}
+	return updated, nil
+}
+
+func (r *RpcPlugin) fieldManager() string {
+	if r.FieldManager == "" {
+		return DefaultFieldManager
+	}
+	return r.FieldManager
+}
+
+// weightsChanged reports whether the services' weights of the desired httpproxy differ from the observed ones,
+// it fails if the routes of both don't have the same services.
+func weightsChanged(observed, desired *contourv1.HTTPProxy) (bool, error) {
+	if len(observed.Spec.Routes) != len(desired.Spec.Routes) {
+		return false, fmt.Errorf("the routes of the httpproxy have been changed")
+	}
+
+	changed := false
+	for i := range desired.Spec.Routes {
+		observedSvcs := observed.Spec.Routes[i].Services
+		desiredSvcs := desired.Spec.Routes[i].Services
+		if len(observedSvcs) != len(desiredSvcs) {
+			return false, fmt.Errorf("the services of the route %d of the httpproxy have been changed", i)
+		}
+
+		for j := range desiredSvcs {
+			if observedSvcs[j].Name != desiredSvcs[j].Name {
+				return false, fmt.Errorf("the services of the route %d of the httpproxy have been changed", i)
+			}
+			if observedSvcs[j].Weight != desiredSvcs[j].Weight {
+				changed = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// createPatch returns a JSON patch which changes the services' weights of the observed httpproxy
+// to the desired ones, it returns a nil patch when the weights are already the desired ones.
+// Every changed weight is guarded by test operations, so the patch fails instead of changing
+// another service if the routes have been changed since.
+func createPatch(observed, desired *contourv1.HTTPProxy) ([]byte, types.PatchType, error) {
+	changed, err := weightsChanged(observed, desired)
+	if err != nil || !changed {
+		return nil, types.JSONPatchType, err
+	}
+
+	ops := []patchOperation{}
+	// the resourceVersion makes the API server reject the patch with a conflict
+	// if the httpproxy has been changed since it was read.
+	if observed.ResourceVersion != "" {
+		ops = append(ops, patchOperation{Op: "replace", Path: "/metadata/resourceVersion", Value: observed.ResourceVersion})
+	}
+
+	for i := range desired.Spec.Routes {
+		observedSvcs := observed.Spec.Routes[i].Services
+		desiredSvcs := desired.Spec.Routes[i].Services
+		for j := range desiredSvcs {
+			if observedSvcs[j].Weight == desiredSvcs[j].Weight {
+				continue
+			}
+
+			path := fmt.Sprintf("/spec/routes/%d/services/%d", i, j)
+			ops = append(ops, patchOperation{Op: "test", Path: path + "/name", Value: observedSvcs[j].Name})
+			// a zero weight may be omitted from the httpproxy, so it can't be tested.
+			if observedSvcs[j].Weight != 0 {
+				ops = append(ops, patchOperation{Op: "test", Path: path + "/weight", Value: observedSvcs[j].Weight})
+			}
+			// "add" replaces the weight as well as it sets an omitted one.
+			ops = append(ops, patchOperation{Op: "add", Path: path + "/weight", Value: desiredSvcs[j].Weight})
+		}
+	}
+
+	if history, ok := desired.Annotations[WeightHistoryAnnotation]; ok && history != observed.Annotations[WeightHistoryAnnotation] {
+		if observed.Annotations == nil {
+			ops = append(ops, patchOperation{Op: "add", Path: "/metadata/annotations", Value: map[string]string{WeightHistoryAnnotation: history}})
+		} else {
+			ops = append(ops, patchOperation{Op: "add", Path: "/metadata/annotations/" + escapeJSONPointer(WeightHistoryAnnotation), Value: history})
+		}
+	}
+
+	patch, err := json.Marshal(ops)
+	return patch, types.JSONPatchType, err
+}
+
+// testFailedText is in the error of a failed test operation of a JSON patch.
+const testFailedText = "testing value"
+
+// testFailedMessage is the message of the API server when an operation of a JSON patch fails, it doesn't
+// return the message of the failed operation.
+var testFailedMessage = apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", "", 0, false).ErrStatus.Message
+
+// isConflict reports whether the patch was rejected because the httpproxy has been changed since it was read.
+func isConflict(err error) bool {
+	return apierrors.IsConflict(err) || isTestFailed(err)
+}
+
+// isTestFailed reports whether a test operation of a JSON patch has failed. The API server reports it as
+// invalid, like the validation errors which can't be fixed by a retry, but without their causes.
+func isTestFailed(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Reason != metav1.StatusReasonInvalid {
+		return false
+	}
+	details := status.Status().Details
+	if details != nil {
+		for _, cause := range details.Causes {
+			if strings.Contains(cause.Message, testFailedText) {
+				return true
+			}
+		}
+		if len(details.Causes) > 0 {
+			return false
+		}
+	}
+	message := status.Status().Message
+	return strings.HasPrefix(message, testFailedMessage) || strings.Contains(message, testFailedText)
+}
+
+// conflictBackoff is the wait before the retries of a conflict, from 10ms it doubles up to a second.
+func (r *RpcPlugin) conflictBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: 10 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    r.ConflictRetries,
+		Cap:      time.Second,
+	}
+}
+
+// escapeJSONPointer escapes a key for a RFC 6901 JSON pointer.
+func escapeJSONPointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -3,19 +3,32 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
 	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
-	jsonpatch "github.com/evanphx/json-patch"
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/notify"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/tracing"
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/utils"
 )
 
@@ -25,101 +38,426 @@ type RpcPlugin struct {
 	IsTest               bool
 	dynamicClient        dynamic.Interface
 	UpdatedMockHTTPProxy *contourv1.HTTPProxy
+	mu                   sync.Mutex
+
+	// ConflictRetries is the number of times a patch is retried when the httpproxy
+	// was changed since it has been read
+	ConflictRetries int
+	// FieldManager is the name of the manager of the fields written by the plugin
+	FieldManager string
+
+	// Concurrency is the maximum number of httpproxies which are read or written at once,
+	// 1 or less handles them one after another
+	Concurrency int
+	// Timeout is the deadline of every request to the API server, zero means no deadline
+	Timeout time.Duration
+
+	// ValidationWait is how long the Valid condition of the updated httpproxies is watched, the httpproxies
+	// are restored when contour rejects them. Zero disables the watch
+	ValidationWait time.Duration
+
+	// EnableCache reads the httpproxies from informers instead of the API server
+	EnableCache bool
+	// CacheNamespaces are the namespaces whose informers are started with the plugin,
+	// the informers of other namespaces are started on their first use
+	CacheNamespaces []string
+	// CacheResync is the resync period of the informers
+	CacheResync time.Duration
+	cache       *httpProxyCache
+
+	// SelfHeal restores the weights of the httpproxies when they are changed by someone else during a rollout
+	SelfHeal bool
+	healer   *driftHealer
+
+	// Notifier sends the weight changes and the errors to webhooks, nil disables them
+	Notifier   *notify.Notifier
+	notifiedMu sync.Mutex
+	// notified is the last Error event of a call of a rollout, until the call succeeds
+	notified map[notifiedCall]notifiedError
+
+	// StrictInit fails InitPlugin when the HTTPProxy CRD isn't served or a permission is missing,
+	// otherwise the problems are only logged
+	StrictInit bool
+	// LogLevelConfigMap is the namespace/name of the configmap the log level is watched from, the diagnostics
+	// check that it can be watched
+	LogLevelConfigMap string
+
+	// recorder emits the events of the plugin on the rollouts and their httpproxies
+	recorder record.EventRecorder
+
+	// WeightHistory is the number of changes of the weights kept in the history annotation of
+	// the httpproxies, 0 disables the history
+	WeightHistory int
+	revisionsMu   sync.Mutex
+	// revisions are the canary revisions of the rollouts passed to UpdateHash
+	revisions map[types.NamespacedName]string
+
+	managedMu sync.Mutex
+	// managed are the httpproxies whose weights have been set by the plugin, served by the status API
+	managed map[types.NamespacedName]*managedHTTPProxy
+	// rollouts forgets the rollouts which are deleted, nil in the tests
+	rollouts *rolloutWatch
+
+	// EnvoyAdminURL is the URL of the admin endpoint of an Envoy whose configuration must have the weights
+	// before they are verified
+	EnvoyAdminURL string
+	// EnvoyNamespace and EnvoyPodSelector select the Envoy pods whose configurations must have the weights
+	// before they are verified, their admin endpoints are read on EnvoyAdminPort
+	EnvoyNamespace   string
+	EnvoyPodSelector string
+	EnvoyAdminPort   int
+	kubeClient       kubernetes.Interface
+	httpClient       *http.Client
+
+	// conflicts counts the patches which were rejected because of a conflict
+	conflicts atomic.Int64
+
+	verificationsMu sync.Mutex
+	// verifications is when the current weight of a rollout has first been not verified
+	verifications map[types.NamespacedName]pendingVerification
 }
 
 type ContourTrafficRouting struct {
 	// HTTPProxies is an array of strings which refer to the names of the HTTPProxies used to route
 	// traffic to the service
 	HTTPProxies []string `json:"httpProxies" protobuf:"bytes,1,name=httpProxies"`
+	// RouteWeightPolicies maps the rollout's weight to a route specific weight,
+	// the first policy matching a route is used
+	RouteWeightPolicies []RouteWeightPolicy `json:"routeWeightPolicies,omitempty" protobuf:"bytes,2,rep,name=routeWeightPolicies"`
+	// EndpointReadiness holds back the canary weight until the canary service has enough ready endpoints
+	EndpointReadiness *EndpointReadiness `json:"endpointReadiness,omitempty" protobuf:"bytes,3,opt,name=endpointReadiness"`
+	// Verification tunes how the weights are verified
+	Verification *Verification `json:"verification,omitempty" protobuf:"bytes,4,opt,name=verification"`
+	// Stages updates the httpproxies stage by stage, each stage is verified before the next one is updated.
+	// The httpproxies which aren't in any stage are updated last
+	Stages []Stage `json:"stages,omitempty" protobuf:"bytes,5,rep,name=stages"`
 }
 
-func (r *RpcPlugin) InitPlugin() pluginTypes.RpcError {
+func (r *RpcPlugin) InitPlugin() (rpcErr pluginTypes.RpcError) {
+	ctx, span := startSpan("InitPlugin", nil)
+	defer endSpan(span, &rpcErr)
+
 	if r.IsTest {
 		return pluginTypes.RpcError{}
 	}
 
 	cfg, err := utils.NewKubeConfig()
 	if err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return newRpcError(err)
 	}
 
 	r.dynamicClient, err = dynamic.NewForConfig(cfg)
 	if err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return newRpcError(err)
+	}
+
+	r.kubeClient, err = kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return newRpcError(err)
+	}
+
+	if err := r.diagnose(ctx); err != nil && r.StrictInit {
+		return newRpcError(fmt.Errorf("the diagnostics have failed: %w", err))
+	}
+
+	if r.recorder == nil {
+		r.recorder = utils.NewEventRecorder(r.kubeClient)
+	}
+
+	if r.rollouts == nil {
+		r.rollouts = newRolloutWatch(r.dynamicClient, wait.NeverStop, r.forgetRollout)
+	}
+
+	if (r.EnableCache || r.SelfHeal) && r.cache == nil && r.healer == nil {
+		watch := newHTTPProxyCache(r.dynamicClient, r.CacheResync, wait.NeverStop)
+		if r.SelfHeal {
+			r.healer = newDriftHealer(r, watch)
+			go r.healer.run(wait.NeverStop)
+		}
+		if r.EnableCache {
+			r.cache = watch
+			for _, namespace := range r.CacheNamespaces {
+				r.cache.informerFor(namespace)
+			}
+		}
 	}
 
 	return pluginTypes.RpcError{}
 }
 
 func (r *RpcPlugin) UpdateHash(rollout *v1alpha1.Rollout, canaryHash, stableHash string, additionalDestinations []v1alpha1.WeightDestination) pluginTypes.RpcError {
+	_, span := startSpan("UpdateHash", rollout)
+	defer span.End()
+
+	// the weights don't depend on the hashes, the canary one is only recorded in the weight history
+	r.setRevision(rollout, canaryHash)
 	return pluginTypes.RpcError{}
 }
 
-func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, canaryWeightPercent int32, additionalDestinations []v1alpha1.WeightDestination) pluginTypes.RpcError {
+func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, canaryWeightPercent int32, additionalDestinations []v1alpha1.WeightDestination) (rpcErr pluginTypes.RpcError) {
+	defer observeCall("SetWeight", time.Now(), &rpcErr)
+	var ctr *ContourTrafficRouting
+	defer func() { r.notifyError("SetWeight", rollout, ctr, canaryWeightPercent, rpcErr) }()
+	ctx, span := startSpan("SetWeight", rollout, tracing.CanaryWeightKey.Int(int(canaryWeightPercent)))
+	defer endSpan(span, &rpcErr)
+
 	if err := validateRolloutParameters(rollout); err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return newRpcError(err)
 	}
+	// the rollout is only read once it is validated, it may be nil
+	ctx = withCallLogger(ctx, rollout, slog.Int("weight", int(canaryWeightPercent)))
 
 	ctr, err := getContourTrafficRouting(rollout)
 	if err != nil {
-		return pluginTypes.RpcError{ErrorString: err.Error()}
+		return newRpcError(err)
 	}
+	span.SetAttributes(tracing.HTTPProxiesKey.StringSlice(ctr.HTTPProxies))
 
-	ctx := context.Background()
+	canary := rollout.Spec.Strategy.Canary
+	if err := r.checkCanaryReadiness(ctx, rollout.Namespace, canary.CanaryService, canary.StableService, ctr.EndpointReadiness, canaryWeightPercent); err != nil {
+		logger(ctx).Warn("the canary weight is held back", slog.Any("err", err))
+		return newRpcError(err)
+	}
 
-	for _, proxy := range ctr.HTTPProxies {
-		slog.Debug("updating httpproxy weight", slog.String("name", proxy))
+	// all the httpproxies are read before any of them is changed, so the ones already
+	// updated can be restored if another one fails.
+	snapshots := make([]*contourv1.HTTPProxy, len(ctr.HTTPProxies))
+	err = utils.ForEach(r.Concurrency, len(ctr.HTTPProxies), func(i int) error {
+		ctx := withHTTPProxyLogger(ctx, ctr.HTTPProxies[i])
+		snapshot, err := r.getHTTPProxy(ctx, rollout.Namespace, ctr.HTTPProxies[i])
+		if err != nil {
+			logger(ctx).Error("failed to get httpproxy", slog.Any("err", err))
+			return err
+		}
+		snapshots[i] = snapshot
+		return nil
+	})
+	if err != nil {
+		return newRpcError(err)
+	}
 
-		if err := r.updateHTTPProxy(ctx, proxy, rollout, canaryWeightPercent); err != nil {
-			slog.Error("failed to update httpproxy", slog.String("name", proxy), slog.Any("err", err))
-			return pluginTypes.RpcError{ErrorString: err.Error()}
+	stages := ctr.stages()
+	updated := make([]*contourv1.HTTPProxy, len(snapshots))
+	// remembered are the httpproxies whose new weight is known to the healer, it may have written them
+	remembered := make([]bool, len(snapshots))
+	var weightChanged atomic.Bool
+	rollback := func(err error) pluginTypes.RpcError {
+		// the healer is stopped first, so it doesn't write the new weights again
+		if r.healer != nil {
+			r.healer.forget(rollout, ctr.HTTPProxies)
+		}
+		// the httpproxies are restored in the reverse order of the stages, the ones which still have
+		// the weights of their snapshot aren't changed and aren't reported as restored
+		toRestore := []*contourv1.HTTPProxy{}
+		for _, stage := range stages {
+			for _, i := range stage.indexes {
+				if updated[i] != nil || remembered[i] {
+					toRestore = append(toRestore, snapshots[i])
+				}
+			}
+		}
+		restored, notRestored, rollbackErr := r.rollbackHTTPProxies(ctx, rollout, toRestore)
+		for _, snapshot := range restored {
+			message := fmt.Sprintf("restored the weights after the canary weight %d has failed: %v", canaryWeightPercent, err)
+			r.recordEvent(rollout, snapshot, corev1.EventTypeWarning, WeightsRestoredReason, message)
+		}
+		if rollbackErr != nil {
+			message := fmt.Sprintf("failed to restore the weights of the httpproxies %s after the canary weight %d has failed: %v",
+				strings.Join(notRestored, ", "), canaryWeightPercent, rollbackErr)
+			r.recordEvent(rollout, nil, corev1.EventTypeWarning, WeightsRestoreFailedReason, message)
+			err = errors.Join(err, rollbackErr)
+		}
+		if len(restored) > 0 {
+			logger(ctx).Info("restored the httpproxies", slog.Int("restored", len(restored)), slog.Int("failed", len(notRestored)))
+		}
+		return newRpcError(err)
+	}
+
+	for n, stage := range stages {
+		if len(stages) > 1 {
+			logger(ctx).Info("updating the httpproxies of the stage", slog.String("stage", stage.name), slog.Any("httpProxies", stage.httpProxies(ctr)))
+		}
+
+		// the weight is remembered before it's written, so the writes aren't taken for a drift. The httpproxies
+		// of the next stages keep their previous weight until their stage is updated
+		if r.healer != nil {
+			r.healer.remember(rollout, ctr, stage.httpProxies(ctr), canaryWeightPercent)
+			for _, i := range stage.indexes {
+				remembered[i] = true
+			}
 		}
 
-		slog.Info("successfully updated httpproxy", slog.String("name", proxy))
+		err = utils.ForEach(r.Concurrency, len(stage.indexes), func(j int) error {
+			i := stage.indexes[j]
+			snapshot := snapshots[i]
+			ctx := withHTTPProxyLogger(ctx, snapshot.Name)
+			logger(ctx).Debug("updating httpproxy weight")
+
+			httpProxy, err := r.updateHTTPProxy(ctx, snapshot, rollout, ctr, canaryWeightPercent)
+			if err != nil {
+				logger(ctx).Error("failed to update httpproxy", slog.Any("err", err))
+				message := fmt.Sprintf("failed to set the canary weight %d: %v", canaryWeightPercent, err)
+				r.recordEvent(rollout, snapshot, corev1.EventTypeWarning, WeightUpdateFailedReason, message)
+				return err
+			}
+			updated[i] = httpProxy
+
+			if changed, _ := weightsChanged(snapshot, httpProxy); changed {
+				weightChanged.Store(true)
+				message := fmt.Sprintf("set the canary weight to %d", canaryWeightPercent)
+				r.recordEvent(rollout, httpProxy, corev1.EventTypeNormal, WeightUpdatedReason, message)
+			}
+
+			logger(ctx).Info("successfully updated httpproxy")
+			return nil
+		})
+		if err != nil {
+			return rollback(err)
+		}
+
+		if r.ValidationWait > 0 {
+			err = utils.ForEach(r.Concurrency, len(stage.indexes), func(j int) error {
+				i := stage.indexes[j]
+				return r.waitForValid(ctx, snapshots[i], updated[i])
+			})
+			if err != nil {
+				logger(ctx).Error("the updated httpproxies are rejected, restoring them", slog.Any("err", err))
+				return rollback(err)
+			}
+		}
+
+		// the last stage is verified by VerifyWeight
+		if n < len(stages)-1 {
+			if err := r.waitForStage(ctx, rollout, ctr, stage, canaryWeightPercent); err != nil {
+				logger(ctx).Error("the stage is not verified, restoring the httpproxies", slog.String("stage", stage.name), slog.Any("err", err))
+				return rollback(err)
+			}
+		}
 	}
 
+	for _, name := range ctr.HTTPProxies {
+		metrics.SetDesiredCanaryWeight(rollout.Namespace, name, canaryWeightPercent)
+	}
+	r.rememberManaged(rollout, ctr, canaryWeightPercent)
+	if weightChanged.Load() {
+		r.notifyWeightChanged(rollout, ctr, canaryWeightPercent)
+	}
 	return pluginTypes.RpcError{}
 }
 
 func (r *RpcPlugin) SetHeaderRoute(rollout *v1alpha1.Rollout, headerRouting *v1alpha1.SetHeaderRoute) pluginTypes.RpcError {
+	_, span := startSpan("SetHeaderRoute", rollout)
+	defer span.End()
 	return pluginTypes.RpcError{}
 }
 
 func (r *RpcPlugin) SetMirrorRoute(rollout *v1alpha1.Rollout, setMirrorRoute *v1alpha1.SetMirrorRoute) pluginTypes.RpcError {
+	_, span := startSpan("SetMirrorRoute", rollout)
+	defer span.End()
 	return pluginTypes.RpcError{}
 }
 
-func (r *RpcPlugin) VerifyWeight(rollout *v1alpha1.Rollout, canaryWeightPercent int32, additionalDestinations []v1alpha1.WeightDestination) (pluginTypes.RpcVerified, pluginTypes.RpcError) {
+func (r *RpcPlugin) VerifyWeight(rollout *v1alpha1.Rollout, canaryWeightPercent int32, additionalDestinations []v1alpha1.WeightDestination) (verified pluginTypes.RpcVerified, rpcErr pluginTypes.RpcError) {
+	defer observeVerifyCall(time.Now(), &verified, &rpcErr)
+	var ctr *ContourTrafficRouting
+	defer func() { r.notifyError("VerifyWeight", rollout, ctr, canaryWeightPercent, rpcErr) }()
+	ctx, span := startSpan("VerifyWeight", rollout, tracing.CanaryWeightKey.Int(int(canaryWeightPercent)))
+	defer endSpan(span, &rpcErr)
+
 	if err := validateRolloutParameters(rollout); err != nil {
-		return pluginTypes.NotVerified, pluginTypes.RpcError{ErrorString: err.Error()}
+		return pluginTypes.NotVerified, newRpcError(err)
 	}
+	// the rollout is only read once it is validated, it may be nil
+	ctx = withCallLogger(ctx, rollout, slog.Int("weight", int(canaryWeightPercent)))
 
 	ctr, err := getContourTrafficRouting(rollout)
 	if err != nil {
-		return pluginTypes.NotVerified, pluginTypes.RpcError{ErrorString: err.Error()}
+		return pluginTypes.NotVerified, newRpcError(err)
 	}
 
-	ctx := context.Background()
+	span.SetAttributes(tracing.HTTPProxiesKey.StringSlice(ctr.HTTPProxies))
 
-	for _, proxy := range ctr.HTTPProxies {
-		slog.Debug("verifying httpproxy", slog.String("name", proxy))
+	err = r.verifyWeight(ctx, rollout, ctr, canaryWeightPercent)
+	stalled, err := r.trackVerification(ctx, rollout, ctr, canaryWeightPercent, err)
+	if stalled {
+		r.recordVerificationStalled(ctx, rollout, ctr, canaryWeightPercent, err)
+	}
+	// Argo Rollouts checks a weight which isn't verified yet again later, but only emits an event for an error,
+	// so the reasons are emitted on the rollout whenever they change. The verification timeout is the error.
+	if errors.Is(err, ErrNotVerified) {
+		logger(ctx).Info("the weights are not verified yet", slog.String("reasons", err.Error()))
+		if r.reasonsChanged(rollout, err.Error()) {
+			message := fmt.Sprintf("the canary weight %d is not verified yet: %v", canaryWeightPercent, err)
+			r.recordEvent(rollout, nil, corev1.EventTypeNormal, WeightNotVerifiedReason, message)
+		}
+		return pluginTypes.NotVerified, pluginTypes.RpcError{}
+	}
+	if err != nil {
+		return pluginTypes.NotVerified, newRpcError(err)
+	}
+	return pluginTypes.Verified, pluginTypes.RpcError{}
+}
+
+// verifyWeight returns an ErrNotVerified error listing the reasons why the weights of the httpproxies,
+// or of the envoys when they are verified too, aren't the rollout's weight.
+func (r *RpcPlugin) verifyWeight(ctx context.Context, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32) error {
+	reasons := make([][]verificationReason, len(ctr.HTTPProxies))
+	err := utils.ForEach(r.Concurrency, len(ctr.HTTPProxies), func(i int) error {
+		proxy := ctr.HTTPProxies[i]
+		ctx := withHTTPProxyLogger(ctx, proxy)
+		logger(ctx).Debug("verifying httpproxy")
 
-		verified, err := r.verifyHTTPProxy(ctx, proxy, rollout, canaryWeightPercent)
+		proxyReasons, err := r.verifyHTTPProxy(ctx, proxy, rollout, ctr, canaryWeightPercent)
 		if err != nil {
-			slog.Error("failed to verify httpproxy", slog.String("name", proxy), slog.Any("err", err))
-			return pluginTypes.NotVerified, pluginTypes.RpcError{ErrorString: err.Error()}
+			logger(ctx).Error("failed to verify httpproxy", slog.Any("err", err))
+			return err
 		}
-		if !verified {
-			return pluginTypes.NotVerified, pluginTypes.RpcError{}
+		reasons[i] = proxyReasons
+
+		if len(proxyReasons) == 0 {
+			logger(ctx).Info("successfully verified httpproxy")
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		slog.Info("successfully verified httpproxy", slog.String("name", proxy))
+	if err := notVerifiedError(reasons); err != nil {
+		r.recordVerification(rollout, ctr, canaryWeightPercent, reasons)
+		return err
 	}
 
-	return pluginTypes.Verified, pluginTypes.RpcError{}
+	// the httpproxies are valid, but envoy may not have received their weights yet
+	if r.verifiesEnvoy() {
+		envoyReasons, err := r.verifyEnvoy(ctx, rollout, ctr, canaryWeightPercent)
+		if err != nil {
+			logger(ctx).Error("failed to verify the envoy configuration", slog.Any("err", err))
+			return err
+		}
+		r.recordVerification(rollout, ctr, canaryWeightPercent, envoyReasons)
+		return notVerifiedError(envoyReasons)
+	}
+	r.recordVerification(rollout, ctr, canaryWeightPercent, nil)
+	return nil
 }
 
-func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.RpcError {
+func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) (rpcErr pluginTypes.RpcError) {
+	_, span := startSpan("RemoveManagedRoutes", rollout)
+	defer endSpan(span, &rpcErr)
+
+	if err := validateRolloutParameters(rollout); err != nil {
+		return newRpcError(err)
+	}
+
+	ctr, err := getContourTrafficRouting(rollout)
+	if err != nil {
+		return newRpcError(err)
+	}
+	r.forgetManaged(rollout, ctr)
+	if r.healer != nil {
+		r.healer.forget(rollout, ctr.HTTPProxies)
+	}
 	return pluginTypes.RpcError{}
 }
 
@@ -127,12 +465,45 @@ func (r *RpcPlugin) Type() string {
 	return Type
 }
 
-func (r *RpcPlugin) getHTTPProxy(ctx context.Context, namespace string, name string) (*contourv1.HTTPProxy, error) {
+// getHTTPProxy returns the httpproxy from the cache when it's up to date, otherwise from the API server.
+func (r *RpcPlugin) getHTTPProxy(ctx context.Context, namespace string, name string) (httpProxy *contourv1.HTTPProxy, err error) {
+	ctx, span := tracing.Start(ctx, "getHTTPProxy", tracing.NamespaceKey.String(namespace), tracing.HTTPProxyKey.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	if r.cache != nil {
+		if httpProxy, ok := r.cache.get(namespace, name); ok {
+			span.SetAttributes(tracing.CacheHitKey.Bool(true))
+			return httpProxy, nil
+		}
+	}
+	span.SetAttributes(tracing.CacheHitKey.Bool(false))
+	return r.fetchHTTPProxy(ctx, namespace, name)
+}
+
+// fetchHTTPProxy returns the httpproxy from the API server.
+func (r *RpcPlugin) fetchHTTPProxy(ctx context.Context, namespace string, name string) (httpProxy *contourv1.HTTPProxy, err error) {
+	ctx, span := tracing.Start(ctx, "fetchHTTPProxy", tracing.NamespaceKey.String(namespace), tracing.HTTPProxyKey.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	unstr, err := r.dynamicClient.Resource(contourv1.HTTPProxyGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get the httpproxy: %w", err)
+		return nil, fmt.Errorf("failed to get the httpproxy %s/%s: %w", namespace, name, err)
 	}
+	return convertHTTPProxy(unstr)
+}
+
+// withTimeout returns the context of a request to the API server.
+func (r *RpcPlugin) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.Timeout)
+}
 
+func convertHTTPProxy(unstr *unstructured.Unstructured) (*contourv1.HTTPProxy, error) {
 	var httpProxy contourv1.HTTPProxy
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstr.UnstructuredContent(), &httpProxy); err != nil {
 		return nil, fmt.Errorf("failed to convert the httpproxy: %w", err)
@@ -140,130 +511,118 @@ func (r *RpcPlugin) getHTTPProxy(ctx context.Context, namespace string, name str
 	return &httpProxy, nil
 }
 
+// updateHTTPProxy sets the weights of the httpproxy to the rollout's weight and returns the updated httpproxy,
+// the given httpproxy is used as the observed one for the first attempt.
 func (r *RpcPlugin) updateHTTPProxy(
 	ctx context.Context,
-	httpProxyName string,
+	httpProxy *contourv1.HTTPProxy,
 	rollout *v1alpha1.Rollout,
-	canaryWeightPercent int32) error {
-
-	httpProxy, err := r.getHTTPProxy(ctx, rollout.Namespace, httpProxyName)
-	if err != nil {
-		return err
-	}
-
-	patchData, patchType, err := createPatch(httpProxy, rollout, canaryWeightPercent)
-	if err != nil {
-		return fmt.Errorf("failed to create patch : %w", err)
-	}
-	updated, err := r.dynamicClient.Resource(contourv1.HTTPProxyGVR).Namespace(rollout.Namespace).Patch(ctx, httpProxyName, patchType, patchData, metav1.PatchOptions{})
-	if err != nil {
-		return err
-	}
+	ctr *ContourTrafficRouting,
+	canaryWeightPercent int32) (*contourv1.HTTPProxy, error) {
 
-	if r.IsTest {
-		var proxy contourv1.HTTPProxy
-		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(updated.UnstructuredContent(), &proxy); err != nil {
-			return err
+	updated, err := r.patchHTTPProxy(ctx, httpProxy, func(observed *contourv1.HTTPProxy) (*contourv1.HTTPProxy, error) {
+		desired, err := getDesiredHTTPProxy(ctx, observed, rollout, ctr, canaryWeightPercent)
+		if err != nil {
+			return nil, err
 		}
-		r.UpdatedMockHTTPProxy = &proxy
-	}
-
-	return nil
-}
-
-func createPatch(httpProxy *contourv1.HTTPProxy, rollout *v1alpha1.Rollout, canaryWeightPercent int32) ([]byte, types.PatchType, error) {
-	oldData, err := json.Marshal(httpProxy.DeepCopy())
+		r.recordWeightHistory(ctx, observed, desired, rollout)
+		return desired, nil
+	})
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to marshal the current configuration: %w", err)
-	}
-
-	canarySvcs, stableSvcs, totalWeights, err := getRouteServices(httpProxy, rollout)
-	if err != nil {
-		return nil, types.MergePatchType, err
-	}
-
-	for i := range canarySvcs {
-		slog.Debug("old weight", slog.Int64("canary", canarySvcs[i].Weight), slog.Int64("stable", stableSvcs[i].Weight))
-		canarySvcs[i].Weight, stableSvcs[i].Weight = utils.CalcWeight(totalWeights[i], float32(canaryWeightPercent))
-		slog.Debug("new weight", slog.Int64("canary", canarySvcs[i].Weight), slog.Int64("stable", stableSvcs[i].Weight))
+		return nil, err
 	}
 
-	newData, err := json.Marshal(httpProxy)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to marshal the current configuration: %w", err)
+	if r.IsTest {
+		r.mu.Lock()
+		r.UpdatedMockHTTPProxy = updated
+		r.mu.Unlock()
 	}
 
-	// now default use json merge patch.
-	patch, err := jsonpatch.CreateMergePatch(oldData, newData)
-	return patch, types.MergePatchType, err
+	return updated, nil
 }
 
+// verifyHTTPProxy returns the reasons why the weights of the httpproxy aren't verified,
+// the httpproxy is verified when there are none.
 func (r *RpcPlugin) verifyHTTPProxy(
 	ctx context.Context,
 	httpProxyName string,
 	rollout *v1alpha1.Rollout,
-	canaryWeightPercent int32) (bool, error) {
+	ctr *ContourTrafficRouting,
+	canaryWeightPercent int32) ([]verificationReason, error) {
 
 	httpProxy, err := r.getHTTPProxy(ctx, rollout.Namespace, httpProxyName)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	notVerified := func(reason, message string) []verificationReason {
+		logger(ctx).Debug(message, slog.String("reason", reason))
+		return []verificationReason{{httpProxy: httpProxyName, reason: reason, message: message}}
 	}
 
 	validCondition := httpProxy.Status.GetConditionFor(contourv1.ValidConditionType)
 	if validCondition == nil {
-		slog.Debug("unable to find valid status condition", slog.String("name", httpProxyName))
-		return false, nil
+		return notVerified(ReasonValidConditionMissing, "unable to find valid status condition"), nil
 	}
 	if validCondition.Status != metav1.ConditionTrue {
-		slog.Debug(fmt.Sprintf("condition status is not %s", metav1.ConditionTrue), slog.String("name", httpProxyName))
-		return false, nil
+		reasons := notVerified(ReasonValidConditionNotTrue, fmt.Sprintf("condition status is %s: %s", validCondition.Status, validCondition.Message))
+		return append(reasons, subConditionReasons(httpProxyName, validCondition)...), nil
 	}
 	if validCondition.ObservedGeneration != httpProxy.Generation {
-		slog.Debug("condition is out of date with respect to the current state of the instance", slog.String("name", httpProxyName))
-		return false, nil
+		return notVerified(ReasonValidConditionOutdated, fmt.Sprintf("condition is observed for generation %d, but the generation is %d", validCondition.ObservedGeneration, httpProxy.Generation)), nil
 	}
 
-	canarySvcs, stableSvcs, totalWeights, err := getRouteServices(httpProxy, rollout)
+	routeSvcs, err := getRouteServices(ctx, httpProxy, rollout)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for i := range canarySvcs {
-		canaryWeight, stableWeight := utils.CalcWeight(totalWeights[i], float32(canaryWeightPercent))
-		if canarySvcs[i].Weight != canaryWeight || stableSvcs[i].Weight != stableWeight {
-			slog.Debug(fmt.Sprintf("expected weights are canary=%d and stable=%d, but got canary=%d and stable=%d", canaryWeight, stableWeight, canarySvcs[i].Weight, stableSvcs[i].Weight), slog.String("name", httpProxyName))
-			return false, nil
+	reasons := []verificationReason{}
+	for _, rs := range routeSvcs {
+		weight := ctr.routeWeight(rs.route, canaryWeightPercent)
+		canaryWeight, stableWeight := utils.CalcWeight(rs.totalWeight, float32(weight))
+		if !ctr.Verification.withinTolerance(rs.canary.Weight, canaryWeight) || !ctr.Verification.withinTolerance(rs.stable.Weight, stableWeight) {
+			reasons = append(reasons, notVerified(ReasonWeightMismatch, fmt.Sprintf("expected weights are canary=%d and stable=%d, but got canary=%d and stable=%d", canaryWeight, stableWeight, rs.canary.Weight, rs.stable.Weight))...)
 		}
 	}
+	if len(reasons) > 0 {
+		// the warnings of contour may tell why the weights aren't the expected ones
+		reasons = append(reasons, subConditionReasons(httpProxyName, validCondition)...)
+	}
 
-	return true, nil
+	return reasons, nil
 }
 
-func getRouteServices(httpProxy *contourv1.HTTPProxy, rollout *v1alpha1.Rollout) (
-	[]*contourv1.Service, []*contourv1.Service, []int64, error) {
+// routeServices holds the canary and stable services of a route which routes traffic to the canary service.
+type routeServices struct {
+	route       *contourv1.Route
+	canary      *contourv1.Service
+	stable      *contourv1.Service
+	totalWeight int64
+}
+
+func getRouteServices(ctx context.Context, httpProxy *contourv1.HTTPProxy, rollout *v1alpha1.Rollout) ([]routeServices, error) {
 	canarySvcName := rollout.Spec.Strategy.Canary.CanaryService
 	stableSvcName := rollout.Spec.Strategy.Canary.StableService
 
-	slog.Debug("the services name", slog.String("stable", stableSvcName), slog.String("canary", canarySvcName))
+	logger(ctx).Debug("the services name", slog.String("stable", stableSvcName), slog.String("canary", canarySvcName))
 
 	svcMaps := getRouteServiceMaps(httpProxy, canarySvcName)
-	canarySvcs := []*contourv1.Service{}
-	stableSvcs := []*contourv1.Service{}
-	totalWeights := []int64{}
+	routeSvcs := []routeServices{}
 
 	for _, svcMap := range svcMaps {
-		canarySvc, err := getService(canarySvcName, svcMap)
+		canarySvc, err := getService(canarySvcName, svcMap.services)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 
-		stableSvc, err := getService(stableSvcName, svcMap)
+		stableSvc, err := getService(stableSvcName, svcMap.services)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 
 		otherWeight := int64(0)
-		for name, svc := range svcMap {
+		for name, svc := range svcMap.services {
 			if name == stableSvcName || name == canarySvcName || svc.Mirror {
 				continue
 			}
@@ -272,21 +631,42 @@ func getRouteServices(httpProxy *contourv1.HTTPProxy, rollout *v1alpha1.Rollout)
 
 		// the total weight must equals to 100
 		if otherWeight+canarySvc.Weight+stableSvc.Weight != 100 {
-			return nil, nil, nil, fmt.Errorf("the total weight must equals to 100")
+			return nil, fmt.Errorf("%w: the total weight must equals to 100", ErrValidation)
 		}
 
-		canarySvcs = append(canarySvcs, canarySvc)
-		stableSvcs = append(stableSvcs, stableSvc)
-		totalWeights = append(totalWeights, 100-otherWeight)
+		routeSvcs = append(routeSvcs, routeServices{
+			route:       svcMap.route,
+			canary:      canarySvc,
+			stable:      stableSvc,
+			totalWeight: 100 - otherWeight,
+		})
 	}
 
-	return canarySvcs, stableSvcs, totalWeights, nil
+	return routeSvcs, nil
 }
 
 func getContourTrafficRouting(rollout *v1alpha1.Rollout) (*ContourTrafficRouting, error) {
 	var ctr ContourTrafficRouting
 	if err := json.Unmarshal(rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[ConfigKey], &ctr); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+	for i := range ctr.RouteWeightPolicies {
+		if err := ctr.RouteWeightPolicies[i].validate(); err != nil {
+			return nil, fmt.Errorf("%w: invalid route weight policy %d: %w", ErrValidation, i, err)
+		}
+	}
+	if ctr.EndpointReadiness != nil {
+		if err := ctr.EndpointReadiness.validate(); err != nil {
+			return nil, fmt.Errorf("%w: invalid endpoint readiness: %w", ErrValidation, err)
+		}
+	}
+	if ctr.Verification != nil {
+		if err := ctr.Verification.validate(); err != nil {
+			return nil, fmt.Errorf("%w: invalid verification: %w", ErrValidation, err)
+		}
+	}
+	if err := ctr.validateStages(); err != nil {
+		return nil, fmt.Errorf("%w: invalid stages: %w", ErrValidation, err)
 	}
 	return &ctr, nil
 }
@@ -294,13 +674,19 @@ func getContourTrafficRouting(rollout *v1alpha1.Rollout) (*ContourTrafficRouting
 func getService(name string, svcMap map[string]*contourv1.Service) (*contourv1.Service, error) {
 	svc, ok := svcMap[name]
 	if !ok {
-		return nil, fmt.Errorf("the service: %s is not found in httpproxy", name)
+		return nil, fmt.Errorf("%w: the service: %s is not found in httpproxy", ErrValidation, name)
 	}
 	return svc, nil
 }
 
-func getRouteServiceMaps(httpProxy *contourv1.HTTPProxy, canarySvcName string) []map[string]*contourv1.Service {
-	svcMaps := []map[string]*contourv1.Service{}
+// routeServiceMap indexes the services of a route by their names.
+type routeServiceMap struct {
+	route    *contourv1.Route
+	services map[string]*contourv1.Service
+}
+
+func getRouteServiceMaps(httpProxy *contourv1.HTTPProxy, canarySvcName string) []routeServiceMap {
+	svcMaps := []routeServiceMap{}
 	// filter the services by canary service name
 	filter := func(services []contourv1.Service) bool {
 		for _, svc := range services {
@@ -311,10 +697,11 @@ func getRouteServiceMaps(httpProxy *contourv1.HTTPProxy, canarySvcName string) [
 		return false
 	}
 
-	for _, r := range httpProxy.Spec.Routes {
+	for i := range httpProxy.Spec.Routes {
+		r := &httpProxy.Spec.Routes[i]
 		svcMap := make(map[string]*contourv1.Service)
 		if filter(r.Services) {
-			svcMaps = append(svcMaps, svcMap)
+			svcMaps = append(svcMaps, routeServiceMap{route: r, services: svcMap})
 			for i := range r.Services {
 				s := &r.Services[i]
 				svcMap[s.Name] = s
@@ -327,7 +714,7 @@ func getRouteServiceMaps(httpProxy *contourv1.HTTPProxy, canarySvcName string) [
 
 func validateRolloutParameters(rollout *v1alpha1.Rollout) error {
 	if rollout == nil || rollout.Spec.Strategy.Canary == nil || rollout.Spec.Strategy.Canary.StableService == "" || rollout.Spec.Strategy.Canary.CanaryService == "" {
-		return fmt.Errorf("illegal parameter(s),both canary service and stable service must be specified")
+		return fmt.Errorf("%w: illegal parameter(s),both canary service and stable service must be specified", ErrValidation)
 	}
 	return nil
 }
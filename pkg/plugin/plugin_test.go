@@ -3,9 +3,12 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,12 +18,17 @@ import (
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
 	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	jsonpatch "github.com/evanphx/json-patch"
 	goPlugin "github.com/hashicorp/go-plugin"
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	fakeDynClient "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 var testHandshake = goPlugin.HandshakeConfig{
@@ -30,7 +38,9 @@ var testHandshake = goPlugin.HandshakeConfig{
 }
 
 func TestRunSuccessfully(t *testing.T) {
-	utils.InitLogger(slog.LevelDebug)
+	if err := utils.InitLogger(slog.LevelDebug, utils.LogFormatText); err != nil {
+		t.Fatal(err)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -192,9 +202,9 @@ func TestRunSuccessfully(t *testing.T) {
 	<-closeCh
 }
 
-func newRollout(stableSvc, canarySvc, httpProxyName string) *v1alpha1.Rollout {
+func newRollout(stableSvc, canarySvc string, httpProxyNames ...string) *v1alpha1.Rollout {
 	contourConfig := ContourTrafficRouting{
-		HTTPProxies: []string{httpProxyName},
+		HTTPProxies: httpProxyNames,
 	}
 	encodedContourConfig, err := json.Marshal(contourConfig)
 	if err != nil {
@@ -223,10 +233,21 @@ func newRollout(stableSvc, canarySvc, httpProxyName string) *v1alpha1.Rollout {
 	}
 }
 
+// setContourTrafficRouting replaces the plugin's config of the rollout.
+func setContourTrafficRouting(t *testing.T, rollout *v1alpha1.Rollout, ctr ContourTrafficRouting) {
+	t.Helper()
+	encodedContourConfig, err := json.Marshal(ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[ConfigKey] = encodedContourConfig
+}
+
 func Test_createPatch(t *testing.T) {
 	type args struct {
 		httpProxy     *contourv1.HTTPProxy
 		rollout       *v1alpha1.Rollout
+		ctr           *ContourTrafficRouting
 		desiredWeight int32
 	}
 	tests := []struct {
@@ -276,8 +297,8 @@ func Test_createPatch(t *testing.T) {
 				},
 				desiredWeight: 50,
 			},
-			want:          []byte(`{"spec":{"routes":[{"services":[{"name":"argo-rollouts-stable","port":0,"weight":45},{"name":"argo-rollouts-canary","port":0,"weight":45},{"name":"others-service","port":0,"weight":10}]}]}}`),
-			wantPatchType: k8stypes.MergePatchType,
+			want:          []byte(`[{"op":"test","path":"/spec/routes/0/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/0/services/0/weight","value":70},{"op":"add","path":"/spec/routes/0/services/0/weight","value":45},{"op":"test","path":"/spec/routes/0/services/1/name","value":"argo-rollouts-canary"},{"op":"test","path":"/spec/routes/0/services/1/weight","value":20},{"op":"add","path":"/spec/routes/0/services/1/weight","value":45}]`),
+			wantPatchType: k8stypes.JSONPatchType,
 			wantErr:       false,
 		},
 		{
@@ -336,8 +357,8 @@ func Test_createPatch(t *testing.T) {
 				},
 				desiredWeight: 50,
 			},
-			want:          []byte(`{"spec":{"routes":[{"services":[{"name":"argo-rollouts-stable","port":0,"weight":45},{"name":"argo-rollouts-canary","port":0,"weight":45},{"name":"others-service","port":0,"weight":10}]},{"services":[{"name":"argo-rollouts-stable","port":0,"weight":40},{"name":"argo-rollouts-canary","port":0,"weight":40},{"name":"others-service","port":0,"weight":20}]}]}}`),
-			wantPatchType: k8stypes.MergePatchType,
+			want:          []byte(`[{"op":"test","path":"/spec/routes/0/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/0/services/0/weight","value":70},{"op":"add","path":"/spec/routes/0/services/0/weight","value":45},{"op":"test","path":"/spec/routes/0/services/1/name","value":"argo-rollouts-canary"},{"op":"test","path":"/spec/routes/0/services/1/weight","value":20},{"op":"add","path":"/spec/routes/0/services/1/weight","value":45},{"op":"test","path":"/spec/routes/1/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/1/services/0/weight","value":70},{"op":"add","path":"/spec/routes/1/services/0/weight","value":40},{"op":"test","path":"/spec/routes/1/services/1/name","value":"argo-rollouts-canary"},{"op":"test","path":"/spec/routes/1/services/1/weight","value":10},{"op":"add","path":"/spec/routes/1/services/1/weight","value":40}]`),
+			wantPatchType: k8stypes.JSONPatchType,
 			wantErr:       false,
 		},
 		{
@@ -385,14 +406,167 @@ func Test_createPatch(t *testing.T) {
 				},
 				desiredWeight: 50,
 			},
-			want:          []byte(`{"spec":{"routes":[{"services":[{"name":"argo-rollouts-stable","port":0,"weight":45},{"name":"argo-rollouts-canary","port":0,"weight":45},{"name":"others-service","port":0,"weight":10},{"mirror":true,"name":"mirrored-service","port":0,"weight":10}]}]}}`),
-			wantPatchType: k8stypes.MergePatchType,
+			want:          []byte(`[{"op":"test","path":"/spec/routes/0/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/0/services/0/weight","value":70},{"op":"add","path":"/spec/routes/0/services/0/weight","value":45},{"op":"test","path":"/spec/routes/0/services/1/name","value":"argo-rollouts-canary"},{"op":"test","path":"/spec/routes/0/services/1/weight","value":20},{"op":"add","path":"/spec/routes/0/services/1/weight","value":45}]`),
+			wantPatchType: k8stypes.JSONPatchType,
+			wantErr:       false,
+		},
+		{
+			name: "test create http proxy patch with route weight policies",
+			args: args{
+				httpProxy: &contourv1.HTTPProxy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: mocks.HTTPProxyName,
+					},
+					Spec: contourv1.HTTPProxySpec{
+						Routes: []contourv1.Route{
+							{
+								Conditions: []contourv1.MatchCondition{{Prefix: "/static"}},
+								Services: []contourv1.Service{
+									{
+										Name:   mocks.StableServiceName,
+										Weight: 100,
+									},
+									{
+										Name:   mocks.CanaryServiceName,
+										Weight: 0,
+									},
+								},
+							},
+							{
+								Conditions: []contourv1.MatchCondition{{Prefix: "/checkout"}},
+								Services: []contourv1.Service{
+									{
+										Name:   mocks.StableServiceName,
+										Weight: 100,
+									},
+									{
+										Name:   mocks.CanaryServiceName,
+										Weight: 0,
+									},
+								},
+							},
+						},
+					},
+				},
+				rollout: &v1alpha1.Rollout{
+					Spec: v1alpha1.RolloutSpec{
+						Strategy: v1alpha1.RolloutStrategy{
+							Canary: &v1alpha1.CanaryStrategy{
+								StableService: mocks.StableServiceName,
+								CanaryService: mocks.CanaryServiceName,
+							},
+						},
+					},
+				},
+				ctr: &ContourTrafficRouting{
+					RouteWeightPolicies: []RouteWeightPolicy{
+						{PathPrefix: "/static", Function: RouteWeightFunctionImmediate},
+						{PathPrefix: "/checkout", Steps: []RouteWeightStep{{Weight: 20, RouteWeight: 5}, {Weight: 50, RouteWeight: 20}}},
+					},
+				},
+				desiredWeight: 50,
+			},
+			want:          []byte(`[{"op":"test","path":"/spec/routes/0/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/0/services/0/weight","value":100},{"op":"add","path":"/spec/routes/0/services/0/weight","value":0},{"op":"test","path":"/spec/routes/0/services/1/name","value":"argo-rollouts-canary"},{"op":"add","path":"/spec/routes/0/services/1/weight","value":100},{"op":"test","path":"/spec/routes/1/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/1/services/0/weight","value":100},{"op":"add","path":"/spec/routes/1/services/0/weight","value":80},{"op":"test","path":"/spec/routes/1/services/1/name","value":"argo-rollouts-canary"},{"op":"add","path":"/spec/routes/1/services/1/weight","value":20}]`),
+			wantPatchType: k8stypes.JSONPatchType,
+			wantErr:       false,
+		},
+		{
+			name: "test create http proxy patch without changes",
+			args: args{
+				httpProxy: &contourv1.HTTPProxy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            mocks.HTTPProxyName,
+						ResourceVersion: "42",
+					},
+					Spec: contourv1.HTTPProxySpec{
+						Routes: []contourv1.Route{
+							{
+								Services: []contourv1.Service{
+									{
+										Name:   mocks.StableServiceName,
+										Weight: 50,
+									},
+									{
+										Name:   mocks.CanaryServiceName,
+										Weight: 50,
+									},
+								},
+							},
+						},
+					},
+				},
+				rollout: &v1alpha1.Rollout{
+					Spec: v1alpha1.RolloutSpec{
+						Strategy: v1alpha1.RolloutStrategy{
+							Canary: &v1alpha1.CanaryStrategy{
+								StableService: mocks.StableServiceName,
+								CanaryService: mocks.CanaryServiceName,
+							},
+						},
+					},
+				},
+				desiredWeight: 50,
+			},
+			want:          nil,
+			wantPatchType: k8stypes.JSONPatchType,
+			wantErr:       false,
+		},
+		{
+			name: "test create http proxy patch with resource version",
+			args: args{
+				httpProxy: &contourv1.HTTPProxy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            mocks.HTTPProxyName,
+						ResourceVersion: "42",
+					},
+					Spec: contourv1.HTTPProxySpec{
+						Routes: []contourv1.Route{
+							{
+								Services: []contourv1.Service{
+									{
+										Name:   mocks.StableServiceName,
+										Weight: 80,
+									},
+									{
+										Name:   mocks.CanaryServiceName,
+										Weight: 20,
+									},
+								},
+							},
+						},
+					},
+				},
+				rollout: &v1alpha1.Rollout{
+					Spec: v1alpha1.RolloutSpec{
+						Strategy: v1alpha1.RolloutStrategy{
+							Canary: &v1alpha1.CanaryStrategy{
+								StableService: mocks.StableServiceName,
+								CanaryService: mocks.CanaryServiceName,
+							},
+						},
+					},
+				},
+				desiredWeight: 50,
+			},
+			want:          []byte(`[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"test","path":"/spec/routes/0/services/0/name","value":"argo-rollouts-stable"},{"op":"test","path":"/spec/routes/0/services/0/weight","value":80},{"op":"add","path":"/spec/routes/0/services/0/weight","value":50},{"op":"test","path":"/spec/routes/0/services/1/name","value":"argo-rollouts-canary"},{"op":"test","path":"/spec/routes/0/services/1/weight","value":20},{"op":"add","path":"/spec/routes/0/services/1/weight","value":50}]`),
+			wantPatchType: k8stypes.JSONPatchType,
 			wantErr:       false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, gotPatchType, err := createPatch(tt.args.httpProxy, tt.args.rollout, tt.args.desiredWeight)
+			ctr := tt.args.ctr
+			if ctr == nil {
+				ctr = &ContourTrafficRouting{}
+			}
+			desired, err := getDesiredHTTPProxy(context.Background(), tt.args.httpProxy, tt.args.rollout, ctr, tt.args.desiredWeight)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("getDesiredHTTPProxy() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			got, gotPatchType, err := createPatch(tt.args.httpProxy, desired)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("createPatch() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -406,3 +580,270 @@ func Test_createPatch(t *testing.T) {
 		})
 	}
 }
+
+func mustGetHTTPProxy(t *testing.T, r *RpcPlugin, name string) *contourv1.HTTPProxy {
+	t.Helper()
+	httpProxy, err := r.getHTTPProxy(context.Background(), "default", name)
+	if err != nil {
+		t.Fatalf("getHTTPProxy() error = %v", err)
+	}
+	return httpProxy
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *fakeDynClient.FakeDynamicClient {
+	s := runtime.NewScheme()
+	_ = contourv1.AddToScheme(s)
+	return fakeDynClient.NewSimpleDynamicClient(s, objects...)
+}
+
+func Test_updateHTTPProxyRetriesOnConflict(t *testing.T) {
+	tests := []struct {
+		name            string
+		conflictRetries int
+		conflicts       int
+		// err is the error of the conflicts, a conflict by default
+		err           error
+		wantConflicts int
+		wantErr       bool
+	}{
+		{
+			name:            "no conflict",
+			conflictRetries: 2,
+			conflicts:       0,
+		},
+		{
+			name:            "retried after conflicts",
+			conflictRetries: 2,
+			conflicts:       2,
+			wantConflicts:   2,
+		},
+		{
+			name:            "too many conflicts",
+			conflictRetries: 2,
+			conflicts:       3,
+			wantConflicts:   3,
+			wantErr:         true,
+		},
+		{
+			name:            "retried after a failed test operation",
+			conflictRetries: 2,
+			conflicts:       1,
+			err:             apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", "testing value /spec/routes/0/services/1/weight failed", 0, false),
+			wantConflicts:   1,
+		},
+		{
+			name:            "not retried after a validation error",
+			conflictRetries: 2,
+			conflicts:       1,
+			err: apierrors.NewInvalid(contourv1.GroupVersion.WithKind("HTTPProxy").GroupKind(), mocks.HTTPProxyName, field.ErrorList{
+				field.Invalid(field.NewPath("spec", "routes").Index(0).Child("services").Index(1).Child("weight"), 150, "must be at most 100"),
+			}),
+			wantConflicts: 0,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			conflicts := 0
+			dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts < tt.conflicts {
+					conflicts++
+					if tt.err != nil {
+						return true, nil, tt.err
+					}
+					return true, nil, apierrors.NewConflict(contourv1.HTTPProxyGVR.GroupResource(), mocks.HTTPProxyName, nil)
+				}
+				return false, nil, nil
+			})
+
+			r := &RpcPlugin{
+				IsTest:          true,
+				dynamicClient:   dynClient,
+				ConflictRetries: tt.conflictRetries,
+			}
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			_, err := r.updateHTTPProxy(context.Background(), mustGetHTTPProxy(t, r, mocks.HTTPProxyName), rollout, &ContourTrafficRouting{}, 50)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateHTTPProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := r.conflicts.Load(); got != int64(tt.wantConflicts) {
+				t.Errorf("conflicts = %d, want %d", got, tt.wantConflicts)
+			}
+			if !tt.wantErr && r.UpdatedMockHTTPProxy.Spec.Routes[0].Services[1].Weight != 50 {
+				t.Errorf("the canary weight is not updated")
+			}
+		})
+	}
+}
+
+func Test_conflictBackoff(t *testing.T) {
+	r := &RpcPlugin{ConflictRetries: 10}
+	backoff := r.conflictBackoff()
+	previous := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		wait := backoff.Step()
+		if wait > 1100*time.Millisecond {
+			t.Fatalf("the wait %d is %s, want it capped at a second", i, wait)
+		}
+		if wait < previous && previous < time.Second {
+			t.Errorf("the wait %d is %s, want it longer than %s", i, wait, previous)
+		}
+		previous = wait
+	}
+	if previous < time.Second {
+		t.Errorf("the last wait is %s, want it to reach the cap", previous)
+	}
+}
+
+func Test_createWeightPatchFailsOnMovedServices(t *testing.T) {
+	httpProxy := &contourv1.HTTPProxy{
+		Spec: contourv1.HTTPProxySpec{
+			Routes: []contourv1.Route{
+				{
+					Services: []contourv1.Service{
+						utils.MakeService(mocks.StableServiceName, 80),
+						utils.MakeService(mocks.CanaryServiceName, 20),
+					},
+				},
+			},
+		},
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	desired, err := getDesiredHTTPProxy(context.Background(), httpProxy, rollout, &ContourTrafficRouting{}, 50)
+	if err != nil {
+		t.Fatalf("getDesiredHTTPProxy() error = %v", err)
+	}
+	patchData, _, err := createPatch(httpProxy, desired)
+	if err != nil {
+		t.Fatalf("createPatch() error = %v", err)
+	}
+	patch, err := jsonpatch.DecodePatch(patchData)
+	if err != nil {
+		t.Fatalf("DecodePatch() error = %v", err)
+	}
+
+	// the services have been reordered since the patch was created
+	moved := httpProxy.DeepCopy()
+	moved.Spec.Routes[0].Services[0], moved.Spec.Routes[0].Services[1] = moved.Spec.Routes[0].Services[1], moved.Spec.Routes[0].Services[0]
+	movedData, err := json.Marshal(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patch.Apply(movedData); err == nil {
+		t.Errorf("the patch should fail on moved services")
+	}
+
+	originData, err := json.Marshal(httpProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patch.Apply(originData); err != nil {
+		t.Errorf("the patch should apply on the origin, but got %v", err)
+	}
+}
+
+func TestSetWeightRollsBackOnPartialFailure(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			var mu sync.Mutex
+			patched := map[string]int{}
+			dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				name := action.(k8stesting.PatchAction).GetName()
+				mu.Lock()
+				patched[name]++
+				mu.Unlock()
+				if name == mocks.ValidHTTPProxyName {
+					return true, nil, apierrors.NewInternalError(fmt.Errorf("boom"))
+				}
+				return false, nil, nil
+			})
+
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: dynClient,
+				Concurrency:   concurrency,
+			}
+			snapshots := map[string]*contourv1.HTTPProxy{
+				mocks.HTTPProxyName:         mustGetHTTPProxy(t, r, mocks.HTTPProxyName),
+				mocks.OutdatedHTTPProxyName: mustGetHTTPProxy(t, r, mocks.OutdatedHTTPProxyName),
+			}
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName, mocks.ValidHTTPProxyName, mocks.OutdatedHTTPProxyName)
+			if err := r.SetWeight(rollout, 50, []v1alpha1.WeightDestination{}); !err.HasError() {
+				t.Fatal("SetWeight() should fail")
+			}
+
+			for name, snapshot := range snapshots {
+				// updated and then restored
+				if patched[name] != 2 {
+					t.Errorf("%s is patched %d times, want 2", name, patched[name])
+				}
+				restored := mustGetHTTPProxy(t, r, name)
+				if !reflect.DeepEqual(restored.Spec.Routes, snapshot.Spec.Routes) {
+					t.Errorf("the routes of %s are not restored, got %+v, want %+v", name, restored.Spec.Routes, snapshot.Spec.Routes)
+				}
+			}
+		})
+	}
+}
+
+func TestSetWeightAndVerifyWeightConcurrently(t *testing.T) {
+	proxies := []string{
+		mocks.HTTPProxyName,
+		mocks.ValidHTTPProxyName,
+		mocks.InvalidHTTPProxyName,
+		mocks.OutdatedHTTPProxyName,
+		mocks.FalseConditionHTTPProxyName,
+	}
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+				Concurrency:   concurrency,
+			}
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, proxies...)
+			if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); err.HasError() {
+				t.Fatalf("SetWeight() error = %v", err)
+			}
+			for _, name := range proxies {
+				svcs := mustGetHTTPProxy(t, r, name).Spec.Routes[0].Services
+				if svcs[0].Weight != 70 || svcs[1].Weight != 30 {
+					t.Errorf("the weights of %s are %d/%d, want 70/30", name, svcs[0].Weight, svcs[1].Weight)
+				}
+			}
+
+			// only the valid httpproxy has an up to date valid condition
+			verified, err := r.VerifyWeight(rollout, 30, []v1alpha1.WeightDestination{})
+			if err.HasError() || verified != types.NotVerified {
+				t.Errorf("VerifyWeight() = %v, %v, want %v", verified, err, types.NotVerified)
+			}
+			rollout = newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+			verified, err = r.VerifyWeight(rollout, 30, []v1alpha1.WeightDestination{})
+			if err.HasError() || verified != types.Verified {
+				t.Errorf("VerifyWeight() = %v, %v, want %v", verified, err, types.Verified)
+			}
+		})
+	}
+}
+
+func TestSetWeightFailsBeforeAnyUpdate(t *testing.T) {
+	dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+	}
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName, "not-found")
+	if err := r.SetWeight(rollout, 50, []v1alpha1.WeightDestination{}); !err.HasError() {
+		t.Fatal("SetWeight() should fail")
+	}
+	for _, action := range dynClient.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("no httpproxy should be patched, but %s is", action.(k8stesting.PatchAction).GetName())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+)
+
+const (
+	// RouteWeightFunctionLinear scales the rollout's weight by the policy's factor.
+	RouteWeightFunctionLinear = "Linear"
+	// RouteWeightFunctionImmediate sends all the traffic to the canary as soon as the rollout's weight is not zero.
+	RouteWeightFunctionImmediate = "Immediate"
+)
+
+// RouteWeightPolicy maps the rollout's canary weight to the canary weight of the routes it matches.
+// A weight of 0 or 100 is never mapped, so a policy can't hold back an abort or a full promotion.
+type RouteWeightPolicy struct {
+	// PathPrefix matches the routes which have a prefix condition equal to it
+	PathPrefix string `json:"pathPrefix,omitempty" protobuf:"bytes,1,opt,name=pathPrefix"`
+	// Conditions matches the routes which have all of these conditions
+	Conditions []contourv1.MatchCondition `json:"conditions,omitempty" protobuf:"bytes,2,rep,name=conditions"`
+	// Steps is a lookup table of the route's weight by the rollout's weight,
+	// it takes precedence over Function
+	Steps []RouteWeightStep `json:"steps,omitempty" protobuf:"bytes,3,rep,name=steps"`
+	// Function is one of Linear (default) or Immediate
+	Function string `json:"function,omitempty" protobuf:"bytes,4,opt,name=function"`
+	// Factor scales the rollout's weight for the Linear function, defaults to 1
+	Factor *float64 `json:"factor,omitempty" protobuf:"fixed64,5,opt,name=factor"`
+}
+
+// RouteWeightStep sets the route's weight once the rollout's weight reaches Weight.
+type RouteWeightStep struct {
+	Weight      int32 `json:"weight" protobuf:"varint,1,name=weight"`
+	RouteWeight int32 `json:"routeWeight" protobuf:"varint,2,name=routeWeight"`
+}
+
+func (p *RouteWeightPolicy) validate() error {
+	if p.PathPrefix == "" && len(p.Conditions) == 0 {
+		return fmt.Errorf("either pathPrefix or conditions must be specified")
+	}
+	switch p.Function {
+	case "", RouteWeightFunctionLinear, RouteWeightFunctionImmediate:
+	default:
+		return fmt.Errorf("unknown function: %s", p.Function)
+	}
+	if p.Factor != nil && *p.Factor < 0 {
+		return fmt.Errorf("factor must not be negative")
+	}
+	for _, step := range p.Steps {
+		if !isValidWeight(step.Weight) || !isValidWeight(step.RouteWeight) {
+			return fmt.Errorf("the weights of a step must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+func (p *RouteWeightPolicy) matches(route *contourv1.Route) bool {
+	if p.PathPrefix != "" {
+		found := false
+		for _, cond := range route.Conditions {
+			if cond.Prefix == p.PathPrefix {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, want := range p.Conditions {
+		found := false
+		for _, cond := range route.Conditions {
+			if reflect.DeepEqual(want, cond) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *RouteWeightPolicy) routeWeight(weight int32) int32 {
+	if weight <= 0 || weight >= 100 {
+		return weight
+	}
+
+	if len(p.Steps) > 0 {
+		steps := make([]RouteWeightStep, len(p.Steps))
+		copy(steps, p.Steps)
+		sort.SliceStable(steps, func(i, j int) bool { return steps[i].Weight < steps[j].Weight })
+
+		routeWeight := int32(0)
+		for _, step := range steps {
+			if step.Weight > weight {
+				break
+			}
+			routeWeight = step.RouteWeight
+		}
+		return routeWeight
+	}
+
+	switch p.Function {
+	case RouteWeightFunctionImmediate:
+		return 100
+	default:
+		factor := 1.0
+		if p.Factor != nil {
+			factor = *p.Factor
+		}
+		return int32(math.Min(100, math.Round(float64(weight)*factor)))
+	}
+}
+
+// routeWeight returns the canary weight of the route, which is the rollout's weight
+// mapped by the first policy matching the route.
+func (ctr *ContourTrafficRouting) routeWeight(route *contourv1.Route, weight int32) int32 {
+	for i := range ctr.RouteWeightPolicies {
+		if p := &ctr.RouteWeightPolicies[i]; p.matches(route) {
+			return p.routeWeight(weight)
+		}
+	}
+	return weight
+}
+
+func isValidWeight(weight int32) bool {
+	return weight >= 0 && weight <= 100
+}
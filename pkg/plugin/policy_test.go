@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"testing"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+)
+
+func TestRouteWeightPolicy_routeWeight(t *testing.T) {
+	half := 0.5
+	tests := []struct {
+		name   string
+		policy RouteWeightPolicy
+		weight int32
+		want   int32
+	}{
+		{
+			name:   "linear by default",
+			policy: RouteWeightPolicy{PathPrefix: "/"},
+			weight: 30,
+			want:   30,
+		},
+		{
+			name:   "linear with factor",
+			policy: RouteWeightPolicy{PathPrefix: "/", Factor: &half},
+			weight: 30,
+			want:   15,
+		},
+		{
+			name:   "immediate",
+			policy: RouteWeightPolicy{PathPrefix: "/", Function: RouteWeightFunctionImmediate},
+			weight: 1,
+			want:   100,
+		},
+		{
+			name:   "immediate keeps zero",
+			policy: RouteWeightPolicy{PathPrefix: "/", Function: RouteWeightFunctionImmediate},
+			weight: 0,
+			want:   0,
+		},
+		{
+			name:   "full promotion is not held back",
+			policy: RouteWeightPolicy{PathPrefix: "/", Factor: &half},
+			weight: 100,
+			want:   100,
+		},
+		{
+			name:   "steps below the first step",
+			policy: RouteWeightPolicy{PathPrefix: "/", Steps: []RouteWeightStep{{Weight: 20, RouteWeight: 5}}},
+			weight: 10,
+			want:   0,
+		},
+		{
+			name:   "steps are looked up in order",
+			policy: RouteWeightPolicy{PathPrefix: "/", Steps: []RouteWeightStep{{Weight: 60, RouteWeight: 30}, {Weight: 20, RouteWeight: 5}}},
+			weight: 59,
+			want:   5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.routeWeight(tt.weight); got != tt.want {
+				t.Errorf("routeWeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteWeightPolicy_matches(t *testing.T) {
+	route := &contourv1.Route{
+		Conditions: []contourv1.MatchCondition{
+			{Prefix: "/checkout"},
+			{Header: &contourv1.HeaderMatchCondition{Name: "x-canary", Present: true}},
+		},
+	}
+	tests := []struct {
+		name   string
+		policy RouteWeightPolicy
+		want   bool
+	}{
+		{
+			name:   "path prefix",
+			policy: RouteWeightPolicy{PathPrefix: "/checkout"},
+			want:   true,
+		},
+		{
+			name:   "other path prefix",
+			policy: RouteWeightPolicy{PathPrefix: "/static"},
+			want:   false,
+		},
+		{
+			name: "conditions",
+			policy: RouteWeightPolicy{Conditions: []contourv1.MatchCondition{
+				{Header: &contourv1.HeaderMatchCondition{Name: "x-canary", Present: true}},
+			}},
+			want: true,
+		},
+		{
+			name: "path prefix and missing condition",
+			policy: RouteWeightPolicy{PathPrefix: "/checkout", Conditions: []contourv1.MatchCondition{
+				{Header: &contourv1.HeaderMatchCondition{Name: "x-other", Present: true}},
+			}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.matches(route); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
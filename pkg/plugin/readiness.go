@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EndpointReadiness holds back the canary weight until the canary service has enough ready endpoints.
+type EndpointReadiness struct {
+	// MinReadyEndpoints is the number of ready endpoints the canary service needs for any weight above 0,
+	// defaults to 1
+	MinReadyEndpoints *int32 `json:"minReadyEndpoints,omitempty" protobuf:"varint,1,opt,name=minReadyEndpoints"`
+	// Proportional requires the ready endpoints of the canary service to be at least the ready endpoints of
+	// the stable service in proportion to the weight, e.g. 2 of 4 for a weight of 50
+	Proportional bool `json:"proportional,omitempty" protobuf:"varint,2,opt,name=proportional"`
+}
+
+func (e *EndpointReadiness) validate() error {
+	if e.MinReadyEndpoints != nil && *e.MinReadyEndpoints < 0 {
+		return fmt.Errorf("minReadyEndpoints must not be negative")
+	}
+	return nil
+}
+
+func (e *EndpointReadiness) minReadyEndpoints() int {
+	if e.MinReadyEndpoints == nil {
+		return 1
+	}
+	return int(*e.MinReadyEndpoints)
+}
+
+// checkCanaryReadiness returns an ErrNotReady error when the canary service hasn't enough ready endpoints
+// for the weight.
+func (r *RpcPlugin) checkCanaryReadiness(ctx context.Context, namespace, canarySvcName, stableSvcName string, readiness *EndpointReadiness, canaryWeightPercent int32) error {
+	if readiness == nil || canaryWeightPercent <= 0 {
+		return nil
+	}
+
+	canaryReady, err := r.countReadyEndpoints(ctx, namespace, canarySvcName)
+	if err != nil {
+		return err
+	}
+
+	required := readiness.minReadyEndpoints()
+	if readiness.Proportional {
+		stableReady, err := r.countReadyEndpoints(ctx, namespace, stableSvcName)
+		if err != nil {
+			return err
+		}
+		required = max(required, int(math.Ceil(float64(stableReady)*float64(canaryWeightPercent)/100)))
+	}
+
+	logger(ctx).Debug("the ready endpoints of the canary service", slog.String("service", canarySvcName), slog.Int("ready", canaryReady), slog.Int("required", required))
+	if canaryReady < required {
+		return fmt.Errorf("%w: the canary service %s/%s has %d ready endpoints, %d are required for the weight %d",
+			ErrNotReady, namespace, canarySvcName, canaryReady, required, canaryWeightPercent)
+	}
+	return nil
+}
+
+// countReadyEndpoints returns the number of ready endpoints in the EndpointSlices of the service.
+func (r *RpcPlugin) countReadyEndpoints(ctx context.Context, namespace, svcName string) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	slices, err := r.kubeClient.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", discoveryv1.LabelServiceName, svcName),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the endpointslices of the service %s/%s: %w", namespace, svcName, err)
+	}
+
+	// an endpoint may be in several slices while they are updated
+	ready := map[string]bool{}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			// a nil ready condition means ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				ready[address] = true
+			}
+		}
+	}
+	return len(ready), nil
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// newEndpointSlice returns an EndpointSlice of the service with the ready and not ready endpoints.
+func newEndpointSlice(svcName string, ready, notReady int) *discoveryv1.EndpointSlice {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName + "-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: svcName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	for i := 0; i < ready+notReady; i++ {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{fmt.Sprintf("10.0.0.%d", i)},
+			Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(i < ready)},
+		})
+	}
+	return slice
+}
+
+func TestSetWeightChecksCanaryReadiness(t *testing.T) {
+	tests := []struct {
+		name         string
+		readiness    *EndpointReadiness
+		canaryReady  int
+		weight       int32
+		wantNotReady bool
+	}{
+		{
+			name:   "check disabled",
+			weight: 50,
+		},
+		{
+			name:         "no ready canary endpoint",
+			readiness:    &EndpointReadiness{},
+			weight:       50,
+			wantNotReady: true,
+		},
+		{
+			name:      "weight 0 is never held back",
+			readiness: &EndpointReadiness{},
+			weight:    0,
+		},
+		{
+			name:        "enough ready canary endpoints",
+			readiness:   &EndpointReadiness{MinReadyEndpoints: ptr.To[int32](2)},
+			canaryReady: 2,
+			weight:      50,
+		},
+		{
+			name:         "not enough ready canary endpoints for the weight",
+			readiness:    &EndpointReadiness{Proportional: true},
+			canaryReady:  2,
+			weight:       50,
+			wantNotReady: true,
+		},
+		{
+			name:        "enough ready canary endpoints for the weight",
+			readiness:   &EndpointReadiness{Proportional: true},
+			canaryReady: 2,
+			weight:      30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+				kubeClient: fake.NewSimpleClientset([]runtime.Object{
+					newEndpointSlice(mocks.CanaryServiceName, tt.canaryReady, 1),
+					newEndpointSlice(mocks.StableServiceName, 6, 0),
+				}...),
+			}
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+				HTTPProxies:       []string{mocks.HTTPProxyName},
+				EndpointReadiness: tt.readiness,
+			})
+
+			err := r.SetWeight(rollout, tt.weight, []v1alpha1.WeightDestination{})
+			if IsNotReadyError(err) != tt.wantNotReady || err.HasError() != tt.wantNotReady {
+				t.Fatalf("SetWeight() error = %v, want not ready %v", err, tt.wantNotReady)
+			}
+
+			want := int64(tt.weight)
+			if tt.wantNotReady {
+				want = mocks.HTTPProxyCanaryWeightPercent
+			}
+			if got := canaryWeightOf(t, r, mocks.HTTPProxyName); got != want {
+				t.Errorf("the canary weight is %d, want %d", got, want)
+			}
+		})
+	}
+}
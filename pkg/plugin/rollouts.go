@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+)
+
+// rolloutWatch watches the rollouts of the namespaces whose httpproxies are managed by the plugin, so the
+// plugin forgets the rollouts which are deleted. Argo Rollouts doesn't call the plugin when a rollout is deleted.
+type rolloutWatch struct {
+	client   dynamic.Interface
+	stopCh   <-chan struct{}
+	onDelete func(key types.NamespacedName)
+
+	mu        sync.Mutex
+	informers map[string]cache.SharedIndexInformer
+}
+
+func newRolloutWatch(client dynamic.Interface, stopCh <-chan struct{}, onDelete func(key types.NamespacedName)) *rolloutWatch {
+	return &rolloutWatch{
+		client:    client,
+		stopCh:    stopCh,
+		onDelete:  onDelete,
+		informers: map[string]cache.SharedIndexInformer{},
+	}
+}
+
+// informerFor returns the informer of the rollouts of the namespace, which is started if it's not yet.
+func (w *rolloutWatch) informerFor(namespace string) cache.SharedIndexInformer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if informer, ok := w.informers[namespace]; ok {
+		return informer
+	}
+
+	slog.Info("starting the rollout informer", slog.String("namespace", namespace))
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client, 0, namespace, nil)
+	informer := factory.ForResource(v1alpha1.RolloutGVR).Informer()
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if unstr, ok := obj.(*unstructured.Unstructured); ok {
+				w.onDelete(types.NamespacedName{Namespace: unstr.GetNamespace(), Name: unstr.GetName()})
+			}
+		},
+	})
+	factory.Start(w.stopCh)
+
+	w.informers[namespace] = informer
+	return informer
+}
+
+// forgetRollout drops what the plugin remembers about the deleted rollout.
+func (r *RpcPlugin) forgetRollout(key types.NamespacedName) {
+	slog.Info("forgetting the deleted rollout", slog.String("rollout", key.Name), slog.String("namespace", key.Namespace))
+
+	r.managedMu.Lock()
+	for name, managed := range r.managed {
+		if managed.rollout.Namespace == key.Namespace && managed.rollout.Name == key.Name {
+			delete(r.managed, name)
+			metrics.DeleteDesiredCanaryWeight(name.Namespace, name.Name)
+		}
+	}
+	r.managedMu.Unlock()
+	metrics.DeleteVerificationWait(key.Namespace, key.Name)
+
+	r.notifiedMu.Lock()
+	for call := range r.notified {
+		if call.rollout == key {
+			delete(r.notified, call)
+		}
+	}
+	r.notifiedMu.Unlock()
+
+	r.verificationsMu.Lock()
+	delete(r.verifications, key)
+	r.verificationsMu.Unlock()
+
+	r.revisionsMu.Lock()
+	delete(r.revisions, key)
+	r.revisionsMu.Unlock()
+
+	if r.healer != nil {
+		r.healer.forgetRollout(key)
+	}
+}
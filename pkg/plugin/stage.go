@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultStageTimeout is how long a stage is waited for to be verified when its timeout isn't set.
+const DefaultStageTimeout = time.Minute
+
+// stagePollInterval is how often the httpproxies of an updated stage are verified.
+var stagePollInterval = time.Second
+
+// Stage is a group of httpproxies which are updated together.
+type Stage struct {
+	// Name identifies the stage in the logs and the errors
+	Name string `json:"name,omitempty" protobuf:"bytes,1,opt,name=name"`
+	// HTTPProxies are the names of the httpproxies of the stage
+	HTTPProxies []string `json:"httpProxies" protobuf:"bytes,2,rep,name=httpProxies"`
+	// Timeout is how long the stage is waited for to be verified before the next one is updated, e.g. 2m,
+	// defaults to 1m. The updated httpproxies are restored when it's not verified in time
+	Timeout string `json:"timeout,omitempty" protobuf:"bytes,3,opt,name=timeout"`
+}
+
+// stage is a Stage resolved to the indexes of its httpproxies in ContourTrafficRouting.HTTPProxies.
+type stage struct {
+	name    string
+	indexes []int
+	timeout time.Duration
+}
+
+func (s stage) httpProxies(ctr *ContourTrafficRouting) []string {
+	names := make([]string, len(s.indexes))
+	for j, i := range s.indexes {
+		names[j] = ctr.HTTPProxies[i]
+	}
+	return names
+}
+
+// validateStages checks the stages, and adds the httpproxies of the stages which aren't
+// in the HTTPProxies to them, so all the httpproxies of the rollout are in the HTTPProxies.
+func (ctr *ContourTrafficRouting) validateStages() error {
+	listed := map[string]bool{}
+	for _, name := range ctr.HTTPProxies {
+		listed[name] = true
+	}
+
+	staged := map[string]bool{}
+	for n, s := range ctr.Stages {
+		if len(s.HTTPProxies) == 0 {
+			return fmt.Errorf("the stage %d has no httpproxy", n)
+		}
+		if s.Timeout != "" {
+			if d, err := time.ParseDuration(s.Timeout); err != nil || d <= 0 {
+				return fmt.Errorf("the timeout of the stage %d must be a positive duration: %q", n, s.Timeout)
+			}
+		}
+		for _, name := range s.HTTPProxies {
+			if staged[name] {
+				return fmt.Errorf("the httpproxy %s is in more than one stage", name)
+			}
+			staged[name] = true
+			if !listed[name] {
+				listed[name] = true
+				ctr.HTTPProxies = append(ctr.HTTPProxies, name)
+			}
+		}
+	}
+	return nil
+}
+
+// stages returns the stages in the order they are updated, the httpproxies which aren't in any stage
+// make the last one. Without stages all the httpproxies are in a single stage.
+func (ctr *ContourTrafficRouting) stages() []stage {
+	index := map[string]int{}
+	for i, name := range ctr.HTTPProxies {
+		index[name] = i
+	}
+
+	stages := []stage{}
+	staged := map[int]bool{}
+	for n, s := range ctr.Stages {
+		st := stage{name: s.Name, timeout: DefaultStageTimeout}
+		if st.name == "" {
+			st.name = fmt.Sprint(n + 1)
+		}
+		if s.Timeout != "" {
+			// validated by getContourTrafficRouting
+			st.timeout, _ = time.ParseDuration(s.Timeout)
+		}
+		for _, name := range s.HTTPProxies {
+			st.indexes = append(st.indexes, index[name])
+			staged[index[name]] = true
+		}
+		stages = append(stages, st)
+	}
+
+	last := stage{name: "unstaged", timeout: DefaultStageTimeout}
+	for i := range ctr.HTTPProxies {
+		if !staged[i] {
+			last.indexes = append(last.indexes, i)
+		}
+	}
+	if len(last.indexes) > 0 {
+		stages = append(stages, last)
+	}
+	return stages
+}
+
+// waitForStage waits until the httpproxies of the stage are verified, and returns an ErrVerificationTimeout
+// error when they aren't within the timeout of the stage.
+func (r *RpcPlugin) waitForStage(ctx context.Context, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, s stage, canaryWeightPercent int32) error {
+	stageCtr := *ctr
+	stageCtr.HTTPProxies = s.httpProxies(ctr)
+
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, stagePollInterval, s.timeout, true, func(ctx context.Context) (bool, error) {
+		lastErr = r.verifyWeight(ctx, rollout, &stageCtr, canaryWeightPercent)
+		if lastErr != nil {
+			logger(ctx).Debug("the stage is not verified yet", slog.String("stage", s.name), slog.Any("err", lastErr))
+		}
+		return lastErr == nil, nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: the stage %s is not verified within %s: %w", ErrVerificationTimeout, s.name, s.timeout, lastErr)
+	}
+	if err == nil {
+		logger(ctx).Info("the stage is verified", slog.String("stage", s.name))
+	}
+	return err
+}
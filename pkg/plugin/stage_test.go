@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_stages(t *testing.T) {
+	ctr := &ContourTrafficRouting{
+		HTTPProxies: []string{"public", "internal", "other"},
+		Stages: []Stage{
+			{Name: "internal", HTTPProxies: []string{"internal", "admin"}, Timeout: "2m"},
+			{HTTPProxies: []string{"public"}},
+		},
+	}
+	if err := ctr.validateStages(); err != nil {
+		t.Fatalf("validateStages() error = %v", err)
+	}
+	if want := []string{"public", "internal", "other", "admin"}; !reflect.DeepEqual(ctr.HTTPProxies, want) {
+		t.Errorf("the httpproxies are %v, want %v", ctr.HTTPProxies, want)
+	}
+
+	got := ctr.stages()
+	want := []stage{
+		{name: "internal", indexes: []int{1, 3}, timeout: 2 * time.Minute},
+		{name: "2", indexes: []int{0}, timeout: DefaultStageTimeout},
+		{name: "unstaged", indexes: []int{2}, timeout: DefaultStageTimeout},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stages() = %+v, want %+v", got, want)
+	}
+
+	for _, stages := range [][]Stage{
+		{{HTTPProxies: []string{}}},
+		{{HTTPProxies: []string{"a"}, Timeout: "never"}},
+		{{HTTPProxies: []string{"a"}}, {HTTPProxies: []string{"a"}}},
+	} {
+		ctr := &ContourTrafficRouting{Stages: stages}
+		if err := ctr.validateStages(); err == nil {
+			t.Errorf("validateStages() should fail for %+v", stages)
+		}
+	}
+}
+
+func TestSetWeightUpdatesStagesInOrder(t *testing.T) {
+	stagePollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		firstStage  string
+		wantPatched []string
+		wantErr     bool
+	}{
+		{
+			name:        "first stage verified",
+			firstStage:  mocks.ValidHTTPProxyName,
+			wantPatched: []string{mocks.ValidHTTPProxyName, mocks.HTTPProxyName},
+		},
+		{
+			// the outdated httpproxy is updated, never verified and restored
+			name:        "first stage not verified",
+			firstStage:  mocks.OutdatedHTTPProxyName,
+			wantPatched: []string{mocks.OutdatedHTTPProxyName, mocks.OutdatedHTTPProxyName},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			var mu sync.Mutex
+			patched := []string{}
+			dynClient.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				patched = append(patched, action.(k8stesting.PatchAction).GetName())
+				return false, nil, nil
+			})
+
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: dynClient,
+			}
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+			setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+				HTTPProxies: []string{mocks.HTTPProxyName},
+				Stages:      []Stage{{Name: "internal", HTTPProxies: []string{tt.firstStage}, Timeout: "100ms"}},
+			})
+
+			err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+			if err.HasError() != tt.wantErr || tt.wantErr && !IsVerificationTimeoutError(err) {
+				t.Fatalf("SetWeight() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(patched, tt.wantPatched) {
+				t.Errorf("the patched httpproxies are %v, want %v", patched, tt.wantPatched)
+			}
+		})
+	}
+}
+
+func TestVerifyWeightVerifiesAllStages(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+		HTTPProxies: []string{mocks.ValidHTTPProxyName},
+		Stages:      []Stage{{HTTPProxies: []string{mocks.OutdatedHTTPProxyName}}},
+	})
+
+	verified, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{})
+	if verified != types.NotVerified || err.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want %v for the staged httpproxy", verified, err, types.NotVerified)
+	}
+}
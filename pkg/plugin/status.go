@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+)
+
+// HTTPProxyStatus is the status of a httpproxy managed by the plugin, served by the status API.
+type HTTPProxyStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Rollout is the name of the rollout which has last set the weights of the httpproxy
+	Rollout string `json:"rollout"`
+	// DesiredWeight is the last canary weight set by the rollout
+	DesiredWeight int32     `json:"desiredWeight"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// LastVerification is the last verification of the weights, if any
+	LastVerification *VerificationStatus `json:"lastVerification,omitempty"`
+	// Routes are the routes of the httpproxy which send traffic to the canary service
+	Routes []RouteStatus `json:"routes,omitempty"`
+	// Error is why the routes can't be read
+	Error string `json:"error,omitempty"`
+}
+
+// VerificationStatus is the result of a verification of the weights of a httpproxy.
+type VerificationStatus struct {
+	Time     time.Time `json:"time"`
+	Weight   int32     `json:"weight"`
+	Verified bool      `json:"verified"`
+	// Reasons are why the weights aren't verified
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// RouteStatus holds the desired and observed weights of the services of a managed route.
+type RouteStatus struct {
+	// Route is the index of the route in the httpproxy
+	Route    int             `json:"route"`
+	Services []ServiceStatus `json:"services"`
+}
+
+// ServiceStatus holds the desired and observed weights of a service.
+type ServiceStatus struct {
+	Name           string `json:"name"`
+	DesiredWeight  int64  `json:"desiredWeight"`
+	ObservedWeight int64  `json:"observedWeight"`
+}
+
+// managedHTTPProxy is what the plugin remembers about a httpproxy it has updated.
+type managedHTTPProxy struct {
+	rollout      *v1alpha1.Rollout
+	ctr          *ContourTrafficRouting
+	weight       int32
+	updatedAt    time.Time
+	verification *VerificationStatus
+}
+
+// rememberManaged records the weight set on the httpproxies of the rollout, a weight of 0 means the rollout
+// has been promoted or aborted and the httpproxies are forgotten.
+func (r *RpcPlugin) rememberManaged(rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32) {
+	if canaryWeightPercent == 0 {
+		r.forgetManaged(rollout, ctr)
+		return
+	}
+	if r.rollouts != nil {
+		r.rollouts.informerFor(rollout.Namespace)
+	}
+
+	r.managedMu.Lock()
+	defer r.managedMu.Unlock()
+	if r.managed == nil {
+		r.managed = map[types.NamespacedName]*managedHTTPProxy{}
+	}
+	for _, name := range ctr.HTTPProxies {
+		key := types.NamespacedName{Namespace: rollout.Namespace, Name: name}
+		managed := &managedHTTPProxy{rollout: rollout, ctr: ctr, weight: canaryWeightPercent, updatedAt: now()}
+		if previous, ok := r.managed[key]; ok && previous.verification != nil && previous.verification.Weight == canaryWeightPercent {
+			managed.verification = previous.verification
+		}
+		r.managed[key] = managed
+	}
+}
+
+// forgetManaged forgets the httpproxies of the rollout, and drops their metrics.
+func (r *RpcPlugin) forgetManaged(rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting) {
+	r.managedMu.Lock()
+	defer r.managedMu.Unlock()
+	for _, name := range ctr.HTTPProxies {
+		delete(r.managed, types.NamespacedName{Namespace: rollout.Namespace, Name: name})
+		metrics.DeleteDesiredCanaryWeight(rollout.Namespace, name)
+	}
+	metrics.DeleteVerificationWait(rollout.Namespace, rollout.Name)
+}
+
+// recordVerification records the result of the verification of the httpproxies of the rollout.
+func (r *RpcPlugin) recordVerification(rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32, reasons [][]verificationReason) {
+	byHTTPProxy := map[string][]string{}
+	for _, proxyReasons := range reasons {
+		for _, reason := range proxyReasons {
+			message := reason.String()
+			if !slices.Contains(byHTTPProxy[reason.httpProxy], message) {
+				byHTTPProxy[reason.httpProxy] = append(byHTTPProxy[reason.httpProxy], message)
+			}
+		}
+	}
+
+	r.managedMu.Lock()
+	defer r.managedMu.Unlock()
+	for _, name := range ctr.HTTPProxies {
+		managed, ok := r.managed[types.NamespacedName{Namespace: rollout.Namespace, Name: name}]
+		if !ok {
+			// the weight hasn't been set by this process, e.g. after a restart
+			continue
+		}
+		managed.verification = &VerificationStatus{
+			Time:     now(),
+			Weight:   canaryWeightPercent,
+			Verified: len(byHTTPProxy[name]) == 0,
+			Reasons:  byHTTPProxy[name],
+		}
+	}
+}
+
+// httpProxyStatuses returns the statuses of the managed httpproxies, sorted by namespace and name.
+func (r *RpcPlugin) httpProxyStatuses(ctx context.Context) []HTTPProxyStatus {
+	r.managedMu.Lock()
+	keys := make([]types.NamespacedName, 0, len(r.managed))
+	managed := make(map[types.NamespacedName]managedHTTPProxy, len(r.managed))
+	for key, m := range r.managed {
+		keys = append(keys, key)
+		managed[key] = *m
+	}
+	r.managedMu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Name < keys[j].Name
+	})
+
+	statuses := make([]HTTPProxyStatus, len(keys))
+	for i, key := range keys {
+		statuses[i] = r.httpProxyStatus(ctx, key, managed[key])
+	}
+	return statuses
+}
+
+// httpProxyStatus reads the httpproxy and compares its weights with the desired ones.
+func (r *RpcPlugin) httpProxyStatus(ctx context.Context, key types.NamespacedName, managed managedHTTPProxy) HTTPProxyStatus {
+	status := HTTPProxyStatus{
+		Namespace:        key.Namespace,
+		Name:             key.Name,
+		Rollout:          managed.rollout.Name,
+		DesiredWeight:    managed.weight,
+		UpdatedAt:        managed.updatedAt,
+		LastVerification: managed.verification,
+	}
+
+	observed, err := r.getHTTPProxy(ctx, key.Namespace, key.Name)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	desired, err := getDesiredHTTPProxy(ctx, observed, managed.rollout, managed.ctr, managed.weight)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	canarySvcName := managed.rollout.Spec.Strategy.Canary.CanaryService
+	for i, route := range observed.Spec.Routes {
+		if !slices.ContainsFunc(route.Services, func(svc contourv1.Service) bool { return svc.Name == canarySvcName }) {
+			continue
+		}
+		routeStatus := RouteStatus{Route: i}
+		for j, svc := range route.Services {
+			routeStatus.Services = append(routeStatus.Services, ServiceStatus{
+				Name:           svc.Name,
+				DesiredWeight:  desired.Spec.Routes[i].Services[j].Weight,
+				ObservedWeight: svc.Weight,
+			})
+		}
+		status.Routes = append(status.Routes, routeStatus)
+	}
+	return status
+}
+
+// StatusHandler serves the statuses of the managed httpproxies as JSON, on /status for all of them
+// and on /status/{namespace}/{name} for one of them.
+func (r *RpcPlugin) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.httpProxyStatuses(req.Context()))
+	})
+	mux.HandleFunc("GET /status/{namespace}/{name}", func(w http.ResponseWriter, req *http.Request) {
+		key := types.NamespacedName{Namespace: req.PathValue("namespace"), Name: req.PathValue("name")}
+		r.managedMu.Lock()
+		m, ok := r.managed[key]
+		var managed managedHTTPProxy
+		if ok {
+			managed = *m
+		}
+		r.managedMu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("the httpproxy %s isn't managed by the plugin", key)})
+			return
+		}
+		writeJSON(w, http.StatusOK, r.httpProxyStatus(req.Context(), key, managed))
+	})
+	return mux
+}
+
+// ServeStatus serves the StatusHandler on the address until it fails.
+func (r *RpcPlugin) ServeStatus(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           r.StatusHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakeDynClient "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestStatusHandler(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+
+	if err := r.SetWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+	if _, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("VerifyWeight() error = %v", err)
+	}
+
+	server := httptest.NewServer(r.StatusHandler())
+	defer server.Close()
+
+	get := func(path string, wantCode int, v any) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantCode {
+			t.Fatalf("GET %s = %s, want %d", path, resp.Status, wantCode)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("failed to decode the response of %s: %v", path, err)
+			}
+		}
+	}
+
+	var statuses []HTTPProxyStatus
+	get("/status", http.StatusOK, &statuses)
+	if len(statuses) != 1 {
+		t.Fatalf("the statuses are %+v, want 1", statuses)
+	}
+	status := statuses[0]
+	if status.Namespace != rollout.Namespace || status.Name != mocks.ValidHTTPProxyName || status.Rollout != rollout.Name {
+		t.Errorf("the status is of %s/%s of %s, want %s/%s of %s",
+			status.Namespace, status.Name, status.Rollout, rollout.Namespace, mocks.ValidHTTPProxyName, rollout.Name)
+	}
+	if status.DesiredWeight != mocks.HTTPProxyCanaryWeightPercent {
+		t.Errorf("the desired weight is %d, want %d", status.DesiredWeight, mocks.HTTPProxyCanaryWeightPercent)
+	}
+	if status.LastVerification == nil || !status.LastVerification.Verified {
+		t.Errorf("the last verification is %+v, want verified", status.LastVerification)
+	}
+	if len(status.Routes) != 1 || len(status.Routes[0].Services) != 2 {
+		t.Fatalf("the routes are %+v, want 1 route with 2 services", status.Routes)
+	}
+	for _, svc := range status.Routes[0].Services {
+		if svc.DesiredWeight != svc.ObservedWeight {
+			t.Errorf("the service %s has the weight %d, want %d", svc.Name, svc.ObservedWeight, svc.DesiredWeight)
+		}
+	}
+
+	// the weights of another verification aren't set yet
+	if verified, _ := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent+10, []v1alpha1.WeightDestination{}); verified == types.Verified {
+		t.Fatalf("VerifyWeight() is verified, want not verified")
+	}
+	get("/status/"+rollout.Namespace+"/"+mocks.ValidHTTPProxyName, http.StatusOK, &status)
+	if status.LastVerification == nil || status.LastVerification.Verified || len(status.LastVerification.Reasons) == 0 {
+		t.Errorf("the last verification is %+v, want not verified with reasons", status.LastVerification)
+	}
+
+	get("/status/"+rollout.Namespace+"/unknown", http.StatusNotFound, nil)
+}
+
+func TestStatusForgetsTheRollouts(t *testing.T) {
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(rollout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolloutObj := &unstructured.Unstructured{Object: content}
+	rolloutObj.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("Rollout"))
+
+	s := runtime.NewScheme()
+	_ = contourv1.AddToScheme(s)
+	dynClient := fakeDynClient.NewSimpleDynamicClientWithCustomListKinds(s,
+		map[schema.GroupVersionResource]string{v1alpha1.RolloutGVR: "RolloutList"},
+		append(mocks.MakeObjects(false), rolloutObj)...)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: dynClient,
+	}
+	r.rollouts = newRolloutWatch(dynClient, stopCh, r.forgetRollout)
+
+	managed := func() int {
+		r.managedMu.Lock()
+		defer r.managedMu.Unlock()
+		return len(r.managed)
+	}
+	gauge := fmt.Sprintf(`rollouts_plugin_contour_desired_canary_weight{httpproxy=%q,namespace=%q}`, mocks.ValidHTTPProxyName, rollout.Namespace)
+	hasGauge := func() bool {
+		rec := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return strings.Contains(rec.Body.String(), gauge)
+	}
+	setWeight := func(weight int32) {
+		t.Helper()
+		if err := r.SetWeight(rollout, weight, []v1alpha1.WeightDestination{}); err.HasError() {
+			t.Fatalf("SetWeight() error = %v", err)
+		}
+	}
+
+	setWeight(mocks.HTTPProxyCanaryWeightPercent)
+	if err := r.RemoveManagedRoutes(rollout); err.HasError() {
+		t.Fatalf("RemoveManagedRoutes() error = %v", err)
+	}
+	if n := managed(); n != 0 {
+		t.Errorf("%d httpproxies are managed after RemoveManagedRoutes, want 0", n)
+	}
+	if hasGauge() {
+		t.Error("the desired canary weight is still exported after RemoveManagedRoutes")
+	}
+
+	// Argo Rollouts sets the weight 0 once the rollout is promoted or aborted
+	setWeight(mocks.HTTPProxyCanaryWeightPercent)
+	setWeight(0)
+	if n := managed(); n != 0 {
+		t.Errorf("%d httpproxies are managed after the weight 0, want 0", n)
+	}
+	if hasGauge() {
+		t.Error("the desired canary weight is still exported after the weight 0")
+	}
+
+	setWeight(mocks.HTTPProxyCanaryWeightPercent)
+	if n := managed(); n != 1 {
+		t.Fatalf("%d httpproxies are managed, want 1", n)
+	}
+	if !hasGauge() {
+		t.Fatal("the desired canary weight isn't exported")
+	}
+	if !cache.WaitForCacheSync(stopCh, r.rollouts.informerFor(rollout.Namespace).HasSynced) {
+		t.Fatal("the rollout informer hasn't synced")
+	}
+	if err := dynClient.Resource(v1alpha1.RolloutGVR).Namespace(rollout.Namespace).Delete(context.Background(), rollout.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for managed() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := managed(); n != 0 {
+		t.Errorf("%d httpproxies are managed after the rollout is deleted, want 0", n)
+	}
+	if hasGauge() {
+		t.Error("the desired canary weight is still exported after the rollout is deleted")
+	}
+}
@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/tracing"
+)
+
+// startSpan starts the span of a call of the plugin. The controller doesn't pass a trace context
+// to the plugin, so the span is a root span.
+func startSpan(name string, rollout *v1alpha1.Rollout, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if rollout != nil {
+		attrs = append(attrs,
+			tracing.RolloutNamespaceKey.String(rollout.Namespace),
+			tracing.RolloutNameKey.String(rollout.Name))
+	}
+	return tracing.Start(context.Background(), name, attrs...)
+}
+
+// endSpan ends the span of a call of the plugin with the error it returns.
+func endSpan(span trace.Span, rpcErr *pluginTypes.RpcError) {
+	var err error
+	if rpcErr.HasError() {
+		err = errors.New(rpcErr.ErrorString)
+	}
+	tracing.End(span, err)
+}
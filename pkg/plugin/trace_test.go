@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/tracing"
+)
+
+func TestSetWeightSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.HTTPProxyName)
+	if err := r.SetWeight(rollout, 30, []v1alpha1.WeightDestination{}); err.HasError() {
+		t.Fatalf("SetWeight() error = %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root, ok := spans["SetWeight"]
+	if !ok {
+		t.Fatalf("no SetWeight span in %v", spans)
+	}
+	attrs := attribute.NewSet(root.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		tracing.RolloutNamespaceKey: attribute.StringValue(rollout.Namespace),
+		tracing.RolloutNameKey:      attribute.StringValue(rollout.Name),
+		tracing.CanaryWeightKey:     attribute.IntValue(30),
+	} {
+		if got, _ := attrs.Value(key); got != want {
+			t.Errorf("the %s attribute is %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+
+	for _, name := range []string{"getHTTPProxy", "writeHTTPProxy"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("the %s span isn't a child of the SetWeight span", name)
+		}
+	}
+}
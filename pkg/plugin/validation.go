@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// validationPollInterval is how often the Valid condition of an updated httpproxy is read.
+var validationPollInterval = 500 * time.Millisecond
+
+// waitForValid waits until contour has processed the generation of the updated httpproxy, and returns an
+// ErrRejected error if contour has marked that generation invalid. A httpproxy whose generation hasn't
+// increased past the snapshot's hasn't been written, so its Valid condition isn't waited for. The httpproxy
+// is taken as valid when contour doesn't process it within the ValidationWait, VerifyWeight will not verify
+// it anyway.
+func (r *RpcPlugin) waitForValid(ctx context.Context, snapshot, updated *contourv1.HTTPProxy) error {
+	ctx = withHTTPProxyLogger(ctx, updated.Name)
+	if updated.Generation <= snapshot.Generation {
+		return nil
+	}
+
+	var rejected error
+	err := wait.PollUntilContextTimeout(ctx, validationPollInterval, r.ValidationWait, true, func(ctx context.Context) (bool, error) {
+		httpProxy, err := r.fetchHTTPProxy(ctx, updated.Namespace, updated.Name)
+		if err != nil {
+			logger(ctx).Debug("failed to get the updated httpproxy", slog.Any("err", err))
+			return false, nil
+		}
+
+		validCondition := httpProxy.Status.GetConditionFor(contourv1.ValidConditionType)
+		if validCondition == nil || validCondition.ObservedGeneration < updated.Generation {
+			return false, nil
+		}
+		// only the generation with the new weights is rejected, a later one has been written by someone else
+		if validCondition.ObservedGeneration == updated.Generation && validCondition.Status == metav1.ConditionFalse {
+			rejected = fmt.Errorf("%w: the httpproxy %s/%s is invalid for the generation %d: %s",
+				ErrRejected, httpProxy.Namespace, httpProxy.Name, validCondition.ObservedGeneration, validCondition.Message)
+		}
+		return true, nil
+	})
+	if rejected != nil {
+		return rejected
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger(ctx).Debug("the updated httpproxy is not processed by contour in time")
+		return nil
+	}
+	return err
+}
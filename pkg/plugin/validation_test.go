@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeDynClient "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// processByContour makes the fake client bump the generation of the httpproxies whose spec is patched, like
+// the API server does, and mark the new generation with the Valid condition status, like contour does.
+// The new generation isn't processed when the status is empty.
+func processByContour(t *testing.T, client *fakeDynClient.FakeDynamicClient, status contourv1.ConditionStatus) {
+	t.Helper()
+	tracker := client.Tracker()
+	client.PrependReactor("patch", "httpproxies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		previous, err := tracker.Get(contourv1.HTTPProxyGVR, action.GetNamespace(), action.(k8stesting.PatchAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		_, obj, err := k8stesting.ObjectReaction(tracker)(action)
+		if err != nil {
+			return true, nil, err
+		}
+
+		var before, after contourv1.HTTPProxy
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(previous.(*unstructured.Unstructured).Object, &before); err != nil {
+			return true, nil, err
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).Object, &after); err != nil {
+			return true, nil, err
+		}
+		if reflect.DeepEqual(before.Spec, after.Spec) {
+			return true, obj, nil
+		}
+
+		after.Generation = before.Generation + 1
+		if status != "" {
+			after.Status.Conditions = []contourv1.DetailedCondition{{Condition: contourv1.Condition{
+				Type:               contourv1.ValidConditionType,
+				Status:             status,
+				ObservedGeneration: after.Generation,
+			}}}
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&after)
+		if err != nil {
+			return true, nil, err
+		}
+		updated := &unstructured.Unstructured{Object: content}
+		if err := tracker.Update(contourv1.HTTPProxyGVR, updated, action.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return true, updated, nil
+	})
+}
+
+func TestSetWeightRestoresRejectedHTTPProxies(t *testing.T) {
+	tests := []struct {
+		name        string
+		httpProxies []string
+		weight      int32
+		// contourStatus is the Valid condition status contour sets for the new generations
+		contourStatus contourv1.ConditionStatus
+		wantRejected  bool
+	}{
+		{
+			name:          "valid httpproxies",
+			httpProxies:   []string{mocks.HTTPProxyName, mocks.ValidHTTPProxyName},
+			contourStatus: contourv1.ConditionTrue,
+		},
+		{
+			name:        "httpproxy not processed by contour in time",
+			httpProxies: []string{mocks.HTTPProxyName, mocks.OutdatedHTTPProxyName},
+		},
+		{
+			name:        "httpproxy already invalid before the update",
+			httpProxies: []string{mocks.HTTPProxyName, mocks.FalseConditionHTTPProxyName},
+		},
+		{
+			name:        "invalid httpproxy not written",
+			httpProxies: []string{mocks.HTTPProxyName, mocks.FalseConditionHTTPProxyName},
+			weight:      mocks.HTTPProxyCanaryWeightPercent,
+		},
+		{
+			name:          "httpproxy rejected by contour",
+			httpProxies:   []string{mocks.HTTPProxyName, mocks.ValidHTTPProxyName},
+			contourStatus: contourv1.ConditionFalse,
+			wantRejected:  true,
+		},
+	}
+
+	validationPollInterval = 10 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dynClient := newFakeDynamicClient(mocks.MakeObjects(false)...)
+			processByContour(t, dynClient, tt.contourStatus)
+			r := &RpcPlugin{
+				IsTest:         true,
+				dynamicClient:  dynClient,
+				ValidationWait: 100 * time.Millisecond,
+			}
+			snapshot := mustGetHTTPProxy(t, r, mocks.HTTPProxyName)
+
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, tt.httpProxies...)
+			weight := tt.weight
+			if weight == 0 {
+				weight = 50
+			}
+			err := r.SetWeight(rollout, weight, []v1alpha1.WeightDestination{})
+			if IsRejectedError(err) != tt.wantRejected || err.HasError() != tt.wantRejected {
+				t.Fatalf("SetWeight() error = %v, want rejected %v", err, tt.wantRejected)
+			}
+
+			routes := mustGetHTTPProxy(t, r, mocks.HTTPProxyName).Spec.Routes
+			// the routes of a httpproxy which isn't written stay the snapshot's
+			wantRestored := tt.wantRejected || weight == mocks.HTTPProxyCanaryWeightPercent
+			if restored := reflect.DeepEqual(routes, snapshot.Spec.Routes); restored != wantRestored {
+				t.Errorf("the httpproxy is restored %v, want %v", restored, wantRestored)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/metrics"
+)
+
+// Verification tunes how the weights are verified.
+type Verification struct {
+	// Tolerance is the difference allowed between the expected and the actual weights of a service
+	Tolerance int64 `json:"tolerance,omitempty" protobuf:"varint,1,opt,name=tolerance"`
+	// Timeout is how long the weight may stay not verified, e.g. 5m. After it VerifyWeight returns an
+	// ErrVerificationTimeout error. Empty means no timeout
+	Timeout string `json:"timeout,omitempty" protobuf:"bytes,2,opt,name=timeout"`
+}
+
+func (v *Verification) validate() error {
+	if v.Tolerance < 0 {
+		return fmt.Errorf("tolerance must not be negative")
+	}
+	if v.Timeout != "" {
+		if d, err := time.ParseDuration(v.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("timeout must be a positive duration: %q", v.Timeout)
+		}
+	}
+	return nil
+}
+
+// tolerance returns the difference allowed between the weights, none for a nil verification.
+func (v *Verification) tolerance() int64 {
+	if v == nil {
+		return 0
+	}
+	return v.Tolerance
+}
+
+// withinTolerance reports whether the actual weight is close enough to the expected one,
+// a nil verification requires them to be equal.
+func (v *Verification) withinTolerance(actual, expected int64) bool {
+	tolerance := v.tolerance()
+	diff := actual - expected
+	return -tolerance <= diff && diff <= tolerance
+}
+
+// timeout returns the verification timeout, zero means none.
+func (v *Verification) timeout() time.Duration {
+	if v == nil || v.Timeout == "" {
+		return 0
+	}
+	// validated by getContourTrafficRouting
+	d, _ := time.ParseDuration(v.Timeout)
+	return d
+}
+
+// pendingVerification is a weight of a rollout which isn't verified yet.
+type pendingVerification struct {
+	weight int32
+	since  time.Time
+	// stalled is set once the VerificationStalled event of the weight has been emitted
+	stalled bool
+	// reasons are the last reasons why the weight isn't verified, emitted in a WeightNotVerified event
+	reasons string
+}
+
+// trackVerification records since when the weight of the rollout isn't verified, and turns the error of the
+// verification into an ErrVerificationTimeout error once the verification timeout has passed, which only keeps
+// its message so that it isn't an ErrNotVerified error. It reports the
+// weight as stalled once, when it has been waiting for longer than the timeout, or DefaultVerificationStall without one.
+func (r *RpcPlugin) trackVerification(ctx context.Context, rollout *v1alpha1.Rollout, ctr *ContourTrafficRouting, canaryWeightPercent int32, err error) (stalled bool, _ error) {
+	key := types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}
+
+	r.verificationsMu.Lock()
+	defer r.verificationsMu.Unlock()
+
+	pending, ok := r.verifications[key]
+	if err == nil {
+		if ok {
+			delete(r.verifications, key)
+			metrics.ObserveVerified(key.Namespace, key.Name, time.Since(pending.since))
+		}
+		return false, nil
+	}
+
+	if r.verifications == nil {
+		r.verifications = map[types.NamespacedName]pendingVerification{}
+	}
+	if !ok || pending.weight != canaryWeightPercent {
+		pending = pendingVerification{weight: canaryWeightPercent, since: time.Now()}
+		r.verifications[key] = pending
+	}
+
+	elapsed := time.Since(pending.since)
+	metrics.SetVerificationWait(key.Namespace, key.Name, elapsed)
+
+	timeout := ctr.Verification.timeout()
+	stallAfter := timeout
+	if stallAfter == 0 {
+		stallAfter = DefaultVerificationStall
+	}
+	if !pending.stalled && elapsed > stallAfter {
+		pending.stalled = true
+		r.verifications[key] = pending
+		stalled = true
+	}
+
+	if timeout > 0 && elapsed > timeout {
+		logger(ctx).Warn("the weight is not verified in time", slog.Duration("elapsed", elapsed))
+		return stalled, fmt.Errorf("%w: the weight %d is not verified within %s: %s", ErrVerificationTimeout, canaryWeightPercent, ctr.Verification.Timeout, err.Error())
+	}
+	return stalled, err
+}
+
+// reasonsChanged records the reasons why the pending weight of the rollout isn't verified, and reports whether
+// they differ from the last ones, so the same reasons are only reported once per weight.
+func (r *RpcPlugin) reasonsChanged(rollout *v1alpha1.Rollout, reasons string) bool {
+	key := types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}
+
+	r.verificationsMu.Lock()
+	defer r.verificationsMu.Unlock()
+
+	pending, ok := r.verifications[key]
+	if !ok || pending.reasons == reasons {
+		return false
+	}
+	pending.reasons = reasons
+	r.verifications[key] = pending
+	return true
+}
+
+// The reasons why the weights of a httpproxy aren't verified.
+const (
+	ReasonValidConditionMissing  = "ValidConditionMissing"
+	ReasonValidConditionNotTrue  = "ValidConditionNotTrue"
+	ReasonValidConditionOutdated = "ValidConditionOutdated"
+	ReasonWeightMismatch         = "WeightMismatch"
+	// ReasonContourError and ReasonContourWarning are the errors and warnings of the Valid condition of the httpproxy.
+	ReasonContourError   = "ContourError"
+	ReasonContourWarning = "ContourWarning"
+)
+
+// verificationReason is a reason why the weights of a httpproxy aren't verified.
+type verificationReason struct {
+	httpProxy string
+	reason    string
+	message   string
+}
+
+func (v verificationReason) String() string {
+	return fmt.Sprintf("httpproxy %s: %s: %s", v.httpProxy, v.reason, v.message)
+}
+
+// subConditionReasons returns the errors and warnings reported by contour in the condition.
+func subConditionReasons(httpProxyName string, condition *contourv1.DetailedCondition) []verificationReason {
+	reasons := []verificationReason{}
+	add := func(reason string, subConditions []contourv1.SubCondition) {
+		for _, sc := range subConditions {
+			reasons = append(reasons, verificationReason{
+				httpProxy: httpProxyName,
+				reason:    reason,
+				message:   fmt.Sprintf("%s(%s): %s", sc.Type, sc.Reason, sc.Message),
+			})
+		}
+	}
+	add(ReasonContourError, condition.Errors)
+	add(ReasonContourWarning, condition.Warnings)
+	return reasons
+}
+
+// notVerifiedError returns an ErrNotVerified error listing the reasons of all the httpproxies, the identical
+// reasons are listed once. It returns nil when there are no reasons.
+func notVerifiedError(reasons [][]verificationReason) error {
+	seen := map[verificationReason]bool{}
+	messages := []string{}
+	for _, proxyReasons := range reasons {
+		for _, reason := range proxyReasons {
+			if seen[reason] {
+				continue
+			}
+			seen[reason] = true
+			messages = append(messages, reason.String())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotVerified, strings.Join(messages, "; "))
+}
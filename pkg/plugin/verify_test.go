@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour/pkg/mocks"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/argoproj/argo-rollouts/utils/plugin/types"
+	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+func Test_verifyHTTPProxyReasons(t *testing.T) {
+	falseCondition := mocks.MakeObjects(false)[4].(*contourv1.HTTPProxy)
+	falseCondition.Status.Conditions[0].Errors = []contourv1.SubCondition{
+		{Type: contourv1.ConditionTypeServiceError, Reason: "ServiceUnresolvedReference", Message: "service not found"},
+	}
+
+	tests := []struct {
+		name        string
+		httpProxy   string
+		weight      int32
+		wantReasons []string
+	}{
+		{
+			name:      "verified",
+			httpProxy: mocks.ValidHTTPProxyName,
+			weight:    mocks.HTTPProxyCanaryWeightPercent,
+		},
+		{
+			name:        "wrong weights",
+			httpProxy:   mocks.ValidHTTPProxyName,
+			weight:      mocks.HTTPProxyCanaryWeightPercent + 10,
+			wantReasons: []string{ReasonWeightMismatch},
+		},
+		{
+			name:        "missing valid condition",
+			httpProxy:   mocks.InvalidHTTPProxyName,
+			weight:      mocks.HTTPProxyCanaryWeightPercent,
+			wantReasons: []string{ReasonValidConditionMissing},
+		},
+		{
+			name:        "outdated valid condition",
+			httpProxy:   mocks.OutdatedHTTPProxyName,
+			weight:      mocks.HTTPProxyCanaryWeightPercent,
+			wantReasons: []string{ReasonValidConditionOutdated},
+		},
+		{
+			name:        "false valid condition with contour errors",
+			httpProxy:   mocks.FalseConditionHTTPProxyName,
+			weight:      mocks.HTTPProxyCanaryWeightPercent,
+			wantReasons: []string{ReasonValidConditionNotTrue, ReasonContourError},
+		},
+	}
+
+	objs := mocks.MakeObjects(false)
+	objs[4] = falseCondition
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(objs...),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, tt.httpProxy)
+			ctr, err := getContourTrafficRouting(rollout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reasons, err := r.verifyHTTPProxy(context.Background(), tt.httpProxy, rollout, ctr, tt.weight)
+			if err != nil {
+				t.Fatalf("verifyHTTPProxy() error = %v", err)
+			}
+			got := []string{}
+			for _, reason := range reasons {
+				got = append(got, reason.reason)
+			}
+			if len(got) != len(tt.wantReasons) || len(got) > 0 && !reflect.DeepEqual(got, tt.wantReasons) {
+				t.Errorf("verifyHTTPProxy() reasons = %v, want %v", got, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestVerifyWeightReturnsDeduplicatedReasons(t *testing.T) {
+	// both routes of the httpproxy have the same wrong weights
+	httpProxy := mocks.MakeObjects(false)[1].(*contourv1.HTTPProxy)
+	httpProxy.Spec.Routes = append(httpProxy.Spec.Routes, *httpProxy.Spec.Routes[0].DeepCopy())
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(httpProxy),
+	}
+
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	ctr, err := getContourTrafficRouting(rollout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.verifyWeight(context.Background(), rollout, ctr, mocks.HTTPProxyCanaryWeightPercent+10)
+	if !errors.Is(err, ErrNotVerified) {
+		t.Fatalf("verifyWeight() error = %v, want a not verified error", err)
+	}
+	if n := strings.Count(err.Error(), ReasonWeightMismatch); n != 1 {
+		t.Errorf("the reason is listed %d times, want 1: %s", n, err)
+	}
+
+	// the reasons are served by the status API, not returned to Argo Rollouts
+	if verified, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent+10, []v1alpha1.WeightDestination{}); verified != types.NotVerified || err.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want %v without an error", verified, err, types.NotVerified)
+	}
+}
+
+func TestVerifyWeightTolerance(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerance int64
+		weight    int32
+		want      types.RpcVerified
+	}{
+		{
+			name:   "exact weights",
+			weight: mocks.HTTPProxyCanaryWeightPercent,
+			want:   types.Verified,
+		},
+		{
+			name:   "no tolerance",
+			weight: mocks.HTTPProxyCanaryWeightPercent + 1,
+			want:   types.NotVerified,
+		},
+		{
+			name:      "within the tolerance",
+			tolerance: 1,
+			weight:    mocks.HTTPProxyCanaryWeightPercent + 1,
+			want:      types.Verified,
+		},
+		{
+			name:      "beyond the tolerance",
+			tolerance: 1,
+			weight:    mocks.HTTPProxyCanaryWeightPercent + 2,
+			want:      types.NotVerified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RpcPlugin{
+				IsTest:        true,
+				dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+			}
+			rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+			setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+				HTTPProxies:  []string{mocks.ValidHTTPProxyName},
+				Verification: &Verification{Tolerance: tt.tolerance},
+			})
+
+			if verified, err := r.VerifyWeight(rollout, tt.weight, []v1alpha1.WeightDestination{}); verified != tt.want {
+				t.Errorf("VerifyWeight() = %v, %v, want %v", verified, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWeightTimeout(t *testing.T) {
+	r := &RpcPlugin{
+		IsTest:        true,
+		dynamicClient: newFakeDynamicClient(mocks.MakeObjects(false)...),
+	}
+	rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+	setContourTrafficRouting(t, rollout, ContourTrafficRouting{
+		HTTPProxies:  []string{mocks.ValidHTTPProxyName},
+		Verification: &Verification{Timeout: "1m"},
+	})
+	key := k8stypes.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Name}
+	expire := func() {
+		r.verificationsMu.Lock()
+		defer r.verificationsMu.Unlock()
+		pending := r.verifications[key]
+		pending.since = pending.since.Add(-2 * time.Minute)
+		r.verifications[key] = pending
+	}
+
+	if verified, err := r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{}); verified != types.NotVerified || err.HasError() {
+		t.Fatalf("VerifyWeight() = %v, %v, want %v without an error", verified, err, types.NotVerified)
+	}
+
+	expire()
+	verified, err := r.VerifyWeight(rollout, 50, []v1alpha1.WeightDestination{})
+	if verified != types.NotVerified || !IsVerificationTimeoutError(err) {
+		t.Fatalf("VerifyWeight() = %v, %v, want a verification timeout error", verified, err)
+	}
+
+	// a new weight has its own timeout
+	if verified, err := r.VerifyWeight(rollout, 60, []v1alpha1.WeightDestination{}); verified != types.NotVerified || err.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want %v without an error", verified, err, types.NotVerified)
+	}
+
+	expire()
+	if verified, err := r.VerifyWeight(rollout, mocks.HTTPProxyCanaryWeightPercent, []v1alpha1.WeightDestination{}); verified != types.Verified || err.HasError() {
+		t.Errorf("VerifyWeight() = %v, %v, want %v", verified, err, types.Verified)
+	}
+	if _, ok := r.verifications[key]; ok {
+		t.Error("the verification should be forgotten once the weight is verified")
+	}
+}
+
+func Test_getContourTrafficRoutingValidatesVerification(t *testing.T) {
+	for _, verification := range []*Verification{{Tolerance: -1}, {Timeout: "soon"}, {Timeout: "-1m"}} {
+		rollout := newRollout(mocks.StableServiceName, mocks.CanaryServiceName, mocks.ValidHTTPProxyName)
+		setContourTrafficRouting(t, rollout, ContourTrafficRouting{Verification: verification})
+		if _, err := getContourTrafficRouting(rollout); !errors.Is(err, ErrValidation) {
+			t.Errorf("getContourTrafficRouting() error = %v, want a validation error for %+v", err, verification)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package tracing traces the calls of the plugin with OpenTelemetry. The spans are dropped until
+// Init is called with an OTLP endpoint.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ServiceName is the name of the service of the spans.
+	ServiceName = "rollouts-plugin-contour"
+
+	tracerName = "github.com/argoproj-labs/rollouts-plugin-trafficrouter-contour"
+)
+
+// The attributes of the spans.
+const (
+	RolloutNamespaceKey = attribute.Key("rollout.namespace")
+	RolloutNameKey      = attribute.Key("rollout.name")
+	CanaryWeightKey     = attribute.Key("rollout.canary_weight")
+	HTTPProxiesKey      = attribute.Key("contour.httpproxies")
+	HTTPProxyKey        = attribute.Key("contour.httpproxy")
+	NamespaceKey        = attribute.Key("k8s.namespace.name")
+	CacheHitKey         = attribute.Key("cache.hit")
+)
+
+// Init exports the spans to the OTLP gRPC endpoint, and returns the function which flushes and stops the export.
+func Init(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the plugin.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error of the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LogLevelKey is the key of the log level in the ConfigMap watched by WatchLogLevelConfigMap.
+const LogLevelKey = "log-level"
+
+var (
+	// logLevel is the level of the default logger, it's changed at runtime by the signal and the ConfigMap.
+	logLevel = &slog.LevelVar{}
+
+	startLevelMu sync.Mutex
+	// startLevel is the level set by InitLogger, it's restored when the ConfigMap no longer sets one.
+	startLevel slog.Level
+)
+
+// SetLogLevel changes the level of the default logger.
+func SetLogLevel(lvl slog.Level) {
+	if old := logLevel.Level(); old != lvl {
+		logLevel.Set(lvl)
+		slog.Info("the log level is changed", slog.String("from", old.String()), slog.String("to", lvl.String()))
+	}
+}
+
+// LogLevel returns the level of the default logger.
+func LogLevel() slog.Level {
+	return logLevel.Level()
+}
+
+// ParseLogLevel parses a level name, e.g. debug or WARN, or a number like the -l flag.
+func ParseLogLevel(value string) (slog.Level, error) {
+	value = strings.TrimSpace(value)
+	if i, err := strconv.Atoi(value); err == nil {
+		return slog.Level(i), nil
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %w", value, err)
+	}
+	return lvl, nil
+}
+
+func setStartLevel(lvl slog.Level) {
+	startLevelMu.Lock()
+	defer startLevelMu.Unlock()
+	startLevel = lvl
+}
+
+func getStartLevel() slog.Level {
+	startLevelMu.Lock()
+	defer startLevelMu.Unlock()
+	return startLevel
+}
+
+// WatchLogLevelConfigMap sets the log level to the LogLevelKey of the ConfigMap whenever it changes, until the
+// stop channel is closed. The level set by InitLogger is restored when the ConfigMap or its key is removed.
+func WatchLogLevelConfigMap(clientset kubernetes.Interface, namespace, name string, resync time.Duration, stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	apply := func(obj any) {
+		configMap, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+		value, ok := configMap.Data[LogLevelKey]
+		if !ok {
+			SetLogLevel(getStartLevel())
+			return
+		}
+		lvl, err := ParseLogLevel(value)
+		if err != nil {
+			slog.Warn("ignoring the log level of the configmap", slog.String("configmap", namespace+"/"+name), slog.Any("err", err))
+			return
+		}
+		SetLogLevel(lvl)
+	}
+
+	_, _ = factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    apply,
+		UpdateFunc: func(_, obj any) { apply(obj) },
+		DeleteFunc: func(any) { SetLogLevel(getStartLevel()) },
+	})
+	factory.Start(stopCh)
+}
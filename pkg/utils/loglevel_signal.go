@@ -0,0 +1,37 @@
+//go:build !windows
+
+package utils
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchLogLevelSignal toggles the log level between debug and the level set by InitLogger on every SIGUSR1,
+// until the stop channel is closed.
+func WatchLogLevelSignal(stopCh <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				toggleDebugLevel()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// toggleDebugLevel sets the log level to debug, or back to the level set by InitLogger when it's already debug.
+func toggleDebugLevel() {
+	if LogLevel() == slog.LevelDebug {
+		SetLogLevel(getStartLevel())
+		return
+	}
+	SetLogLevel(slog.LevelDebug)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package utils
+
+import (
+	"log/slog"
+	"syscall"
+	"testing"
+)
+
+func TestWatchLogLevelSignal(t *testing.T) {
+	resetLogLevel(t)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	WatchLogLevelSignal(stopCh)
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitForLogLevel(t, slog.LevelDebug)
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitForLogLevel(t, slog.LevelInfo)
+}
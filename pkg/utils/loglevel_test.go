@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// resetLogLevel restores the log level of the tests.
+func resetLogLevel(t *testing.T) {
+	t.Helper()
+	previous := slog.Default()
+	if err := InitLogger(slog.LevelInfo, LogFormatText); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logLevel.Set(slog.LevelInfo)
+		slog.SetDefault(previous)
+	})
+}
+
+func waitForLogLevel(t *testing.T, want slog.Level) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return LogLevel() == want, nil
+	})
+	if err != nil {
+		t.Fatalf("the log level is %s, want %s", LogLevel(), want)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for value, want := range map[string]slog.Level{
+		"debug":  slog.LevelDebug,
+		" WARN ": slog.LevelWarn,
+		"info+2": slog.LevelInfo + 2,
+		"-4":     slog.LevelDebug,
+		"8":      slog.LevelError,
+	} {
+		if got, err := ParseLogLevel(value); err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("ParseLogLevel() should fail for an unknown level")
+	}
+}
+
+func TestWatchLogLevelConfigMap(t *testing.T) {
+	resetLogLevel(t)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "argo-rollouts", Name: "contour-plugin"},
+		Data:       map[string]string{LogLevelKey: "debug"},
+	}
+	clientset := fake.NewSimpleClientset(configMap)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	WatchLogLevelConfigMap(clientset, "argo-rollouts", "contour-plugin", 0, stopCh)
+	waitForLogLevel(t, slog.LevelDebug)
+
+	ctx := context.Background()
+	configMap.Data[LogLevelKey] = "error"
+	if _, err := clientset.CoreV1().ConfigMaps("argo-rollouts").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForLogLevel(t, slog.LevelError)
+
+	// an invalid level is ignored
+	configMap.Data[LogLevelKey] = "verbose"
+	if _, err := clientset.CoreV1().ConfigMaps("argo-rollouts").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if LogLevel() != slog.LevelError {
+		t.Errorf("the log level is %s, want it unchanged", LogLevel())
+	}
+
+	if err := clientset.CoreV1().ConfigMaps("argo-rollouts").Delete(ctx, configMap.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForLogLevel(t, slog.LevelInfo)
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package utils
+
+// WatchLogLevelSignal does nothing, there's no SIGUSR1 on Windows.
+func WatchLogLevelSignal(stopCh <-chan struct{}) {}
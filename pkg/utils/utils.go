@@ -1,14 +1,26 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 
+	rolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
 
 	contourv1 "github.com/projectcontour/contour/apis/projectcontour/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 func NewKubeConfig() (*rest.Config, error) {
@@ -24,11 +36,32 @@ func NewKubeConfig() (*rest.Config, error) {
 	return config, nil
 }
 
-func InitLogger(lvl slog.Level) {
-	lvlVar := &slog.LevelVar{}
-	lvlVar.Set(lvl)
+// NewEventRecorder returns a recorder which emits the events of the plugin to the API server,
+// the events can be emitted on the Kubernetes objects, the rollouts and the httpproxies.
+func NewEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	eventScheme := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(eventScheme))
+	utilruntime.Must(rolloutsv1alpha1.AddToScheme(eventScheme))
+	utilruntime.Must(contourv1.AddToScheme(eventScheme))
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(eventScheme, corev1.EventSource{Component: "rollouts-plugin-contour"})
+}
+
+// The formats of the logs.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// InitLogger sets the default logger, which writes the logs to the standard error in the text or JSON format.
+// Its level can be changed at runtime with SetLogLevel.
+func InitLogger(lvl slog.Level, format string) error {
+	logLevel.Set(lvl)
+	setStartLevel(lvl)
 	opts := slog.HandlerOptions{
-		Level: lvlVar,
+		Level: logLevel,
 	}
 
 	attrs := []slog.Attr{
@@ -36,8 +69,34 @@ func InitLogger(lvl slog.Level) {
 		slog.String("vendor", "contour"),
 	}
 
-	l := slog.New(slog.NewTextHandler(os.Stderr, &opts).WithAttrs(attrs))
+	var handler slog.Handler
+	switch format {
+	case LogFormatText, "":
+		handler = slog.NewTextHandler(os.Stderr, &opts)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, &opts)
+	default:
+		return fmt.Errorf("unknown log format %q, it must be %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	l := slog.New(handler.WithAttrs(attrs))
 	slog.SetDefault(l)
+	return nil
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of the context which carries the logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger carried by the context, or the default logger.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
 }
 
 // CalcWeight calc the canary and stable weight from the total weight.
@@ -53,3 +112,29 @@ func MakeService(name string, weight int64) contourv1.Service {
 		Weight: weight,
 	}
 }
+
+// ForEach calls fn for the indexes [0, n) with at most concurrency calls running at once, a concurrency
+// of 1 or less calls fn serially. All the calls are made, and their errors are joined in the order of the indexes.
+func ForEach(concurrency, n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			errs[i] = fn(i)
+		}
+		return errors.Join(errs...)
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			var running, maxRunning, calls atomic.Int32
+			err := ForEach(concurrency, 10, func(i int) error {
+				calls.Add(1)
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					m := maxRunning.Load()
+					if n <= m || maxRunning.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+
+				if i%4 == 1 {
+					return fmt.Errorf("error %d", i)
+				}
+				return nil
+			})
+
+			if calls.Load() != 10 {
+				t.Errorf("fn is called %d times, want 10", calls.Load())
+			}
+			if limit := int32(max(concurrency, 1)); maxRunning.Load() > limit {
+				t.Errorf("%d calls are running at once, want at most %d", maxRunning.Load(), limit)
+			}
+			if want := errors.Join(fmt.Errorf("error 1"), fmt.Errorf("error 5"), fmt.Errorf("error 9")); err == nil || err.Error() != want.Error() {
+				t.Errorf("ForEach() error = %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	if LoggerFrom(context.Background()) != slog.Default() {
+		t.Error("the logger of a context without one should be the default logger")
+	}
+	l := slog.Default().With(slog.String("rollout", "demo"))
+	if LoggerFrom(WithLogger(context.Background(), l)) != l {
+		t.Error("the logger of the context should be returned")
+	}
+}
+
+func TestInitLoggerRejectsUnknownFormat(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	for _, format := range []string{LogFormatText, LogFormatJSON} {
+		if err := InitLogger(slog.LevelInfo, format); err != nil {
+			t.Errorf("InitLogger(%q) error = %v", format, err)
+		}
+	}
+	if err := InitLogger(slog.LevelInfo, "yaml"); err == nil {
+		t.Error("InitLogger() should fail for an unknown format")
+	}
+}